@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"net"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -8,21 +10,169 @@ import (
 	"github.com/ales6164/go-cms/middleware"
 	"github.com/asaskevich/govalidator"
 	"github.com/ales6164/go-cms/kind"
-	"strings"
+	"time"
 	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/ales6164/go-cms/signing"
+	"github.com/ales6164/go-cms/mail"
+	"github.com/ales6164/go-cms/oidc"
+	"sync"
 )
 
+type Options struct {
+	// Store used for kind entries and users
+	// Default: store.NewDatastore()
+	Store store.Store
+	// User group scopes on kinds, e.g. {"public":["post:read"], "editor":["post:*"], "admin":["*:*"]}
+	// Group "public" applies to every caller, including anonymous ones
+	// Default: nil, kind routes are not permission checked
+	Permissions user.Permissions
+	// Lifetime of signed access tokens
+	// Default: instance.DefaultTokenLifetime
+	TokenLifetime time.Duration
+	// Lifetime of refresh tokens (sessions) issued on login
+	// Default: 30 days
+	RefreshTokenLifetime time.Duration
+	// Key signing tokens, e.g. signing.ParsePEM("2017-10", pemData) for RS256/ES256 or signing.NewHMAC for HS256
+	// Required; NewApp panics without it
+	SigningKey *signing.Key
+	// Additional keys accepted when verifying tokens, e.g. signing keys used before rotation
+	VerificationKeys []*signing.Key
+	// Delivers email verification and password reset links
+	// Default: mail.NewLogMailer()
+	Mailer mail.Mailer
+	// Page handling email verification; the token is appended as "token" query parameter
+	// Default: "", only the token is sent
+	VerifyEmailURL string
+	// Page handling password reset; the token is appended as "token" query parameter
+	// Default: "", only the token is sent
+	ResetPasswordURL string
+	// Users can't log in before they verify their email
+	RequireEmailVerification bool
+	// Failed logins after which an account is locked out; negative value disables the limit
+	// Default: 5
+	MaxLoginAttempts int
+	// Failed logins from one IP address after which the address is locked out; negative value disables the limit
+	// Default: 20
+	MaxLoginAttemptsPerIP int
+	// Lockout after reaching the limit; doubles with each further failed attempt up to 24 hours
+	// Default: 15 minutes
+	LoginLockout time.Duration
+	// OpenID Connect providers users can log in with, keyed by name used in /auth/oidc/{provider} routes.
+	// Provider RedirectURL should point to /auth/oidc/{provider}/callback or to a page calling it with
+	// the query parameters it received.
+	IdentityProviders map[string]*oidc.Provider
+	// Name shown in authenticator apps for two-factor authentication
+	// Default: "go-cms"
+	TwoFactorIssuer string
+	// Groups whose scopes only apply to users who logged in with two-factor authentication, e.g. []string{"admin"}
+	RequireTwoFactor []string
+	// Page listing project invitations, linked in invitation emails; the project is appended as "project" query parameter
+	// Default: "", invitations are only listed on /auth/invitations
+	InvitationURL string
+	// Roles project owners can give members, looked up in Permissions like user groups, e.g. []string{"editor", "viewer"};
+	// their scopes apply within the project, so groups like "admin" shouldn't be project roles
+	// Default: []string{"member"}
+	ProjectRoles []string
+	// How often kinds defined at runtime are reloaded from Store, picking up changes made on other instances
+	// Default: 30 seconds
+	KindRefreshInterval time.Duration
+	// Proxies, as IP addresses or CIDR ranges, whose ClientIPHeader gives the client IP address used to limit
+	// logins per IP address, e.g. []string{"10.0.0.0/8"}; NewApp panics on invalid entries
+	// Default: nil, the connection address is used
+	TrustedProxies []string
+	// Header trusted proxies set to the client IP address, e.g. "X-Appengine-User-Ip" on App Engine;
+	// comma separated addresses are read from the last one, skipping trusted proxies
+	// Default: "X-Forwarded-For"
+	ClientIPHeader string
+}
+
 type App struct {
-	PrivateKey []byte
-	Kinds      []*kind.Kind
-	kinds      map[string]*kind.Kind
+	Options Options
+	Kinds   []*kind.Kind // kinds compiled into the binary
+	kinds   map[string]*kind.Kind
+	rules   user.Rules
+	keys    *signing.KeySet
+
+	mu              sync.RWMutex
+	runtimeKinds    map[string]*kind.Kind // kinds defined at runtime keyed by route name
+	runtimeLoadedAt time.Time
+	sharedKinds     map[string]*kind.Kind // sub kinds shared by App kinds keyed by worker type
+	trustedProxies  []*net.IPNet
 }
 
-func NewApp() *App {
+func NewApp(options ...Options) *App {
+	var opts Options
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Store == nil {
+		opts.Store = store.NewDatastore()
+	}
+
+	if opts.TokenLifetime == 0 {
+		opts.TokenLifetime = instance.DefaultTokenLifetime
+	}
+
+	if opts.RefreshTokenLifetime == 0 {
+		opts.RefreshTokenLifetime = time.Hour * 24 * 30
+	}
+
+	if opts.MaxLoginAttempts == 0 {
+		opts.MaxLoginAttempts = 5
+	}
+
+	if opts.MaxLoginAttemptsPerIP == 0 {
+		opts.MaxLoginAttemptsPerIP = 20
+	}
+
+	if opts.LoginLockout == 0 {
+		opts.LoginLockout = time.Minute * 15
+	}
+
+	if len(opts.TwoFactorIssuer) == 0 {
+		opts.TwoFactorIssuer = "go-cms"
+	}
+
+	if opts.KindRefreshInterval == 0 {
+		opts.KindRefreshInterval = time.Second * 30
+	}
+
+	if opts.ProjectRoles == nil {
+		opts.ProjectRoles = []string{"member"}
+	}
+
+	if opts.Mailer == nil {
+		opts.Mailer = mail.NewLogMailer()
+	}
+
+	if len(opts.ClientIPHeader) == 0 {
+		opts.ClientIPHeader = "X-Forwarded-For"
+	}
+
+	keys, err := signing.NewKeySet(opts.SigningKey, opts.VerificationKeys...)
+	if err != nil {
+		panic(err)
+	}
+
+	trustedProxies, err := parseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
 	a := &App{
-		//PrivateKey: securecookie.GenerateRandomKey(64),
-		PrivateKey: []byte("MVoBOkxWGi7pwM1bN9hgxgEVjVXmhTAq"),
-		kinds:      map[string]*kind.Kind{},
+		Options: opts,
+		kinds:   map[string]*kind.Kind{},
+		keys:    keys,
+
+		sharedKinds:    map[string]*kind.Kind{},
+		trustedProxies: trustedProxies,
+	}
+
+	if opts.Permissions != nil {
+		a.rules = opts.Permissions.Parse()
 	}
 
 	govalidator.CustomTypeTagMap.Set("isSlug", govalidator.CustomTypeValidator(IsSlug))
@@ -48,44 +198,212 @@ func NewApp() *App {
 	a.kinds[class.Name] = class*//*
 }*/
 
-func (a *App) Import(kind *kind.Kind) {
-	a.Kinds = append(a.Kinds, kind)
-	a.kinds[kind.Name] = kind
+// Initializes kind field workers and adds the kind with kinds its fields manage, e.g. categories
+// of field.Category; returns worker Init errors and errors of reserved names, e.g. "user" or "Session".
+// Nothing is added when a name is reserved or already imported.
+func (a *App) Import(k *kind.Kind) error {
+	if err := checkKindName(k.Name); err != nil {
+		return err
+	}
+	if err := a.initKind(k); err != nil {
+		return err
+	}
+	var all = append([]*kind.Kind{k}, k.SubKinds()...)
+	for _, e := range all {
+		if err := checkKindName(e.Name); err != nil {
+			return err
+		}
+		if imported, ok := a.kinds[e.Name]; ok && imported != e {
+			return errors.New("kind '" + e.Name + "' is already imported")
+		}
+	}
+	for _, e := range all {
+		if _, ok := a.kinds[e.Name]; ok {
+			continue // sub kind shared by imported kinds
+		}
+		a.Kinds = append(a.Kinds, e)
+		a.kinds[e.Name] = e
+	}
+	return nil
+}
+
+// Shares sub kinds, initializes kind and sets Options.Store on kind and its sub kinds without store
+func (a *App) initKind(k *kind.Kind) error {
+	a.shareSubKinds(k)
+	if err := k.Init(); err != nil {
+		return err
+	}
+	a.setKindStore(k)
+	return nil
+}
+
+// Sets sub kinds shared by App kinds on field workers without one, e.g. categories of field.Category
+func (a *App) shareSubKinds(k *kind.Kind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, f := range k.Fields {
+		w, ok := f.Worker.(kind.SharedSubKindWorker)
+		if !ok || w.SubKind() != nil {
+			continue
+		}
+		name := kind.WorkerType(f.Worker)
+		sub, ok := a.sharedKinds[name]
+		if !ok {
+			sub = w.NewSubKind()
+			a.setKindStore(sub)
+			a.sharedKinds[name] = sub
+		}
+		w.SetSubKind(sub)
+	}
+}
+
+// Sets Options.Store on kind and its sub kinds without store
+func (a *App) setKindStore(k *kind.Kind) {
+	if k.Store == nil {
+		k.Store = a.Options.Store
+	}
+	for _, sub := range k.SubKinds() {
+		a.setKindStore(sub)
+	}
 }
 
 /*
 Only have custom API defined kinds
  */
 func (a *App) Serve(rootPath string) {
-	authMiddleware := middleware.AuthMiddleware(a.PrivateKey)
+	http.Handle(rootPath, &Server{a.router(rootPath)})
+}
+
+// Registers auth and kind routes under rootPath; OpenAPI documents the same routes
+func (a *App) router(rootPath string) *mux.Router {
+	authMiddleware := middleware.AuthMiddleware(a.keys.Keyfunc, a.validateToken)
 	r := mux.NewRouter().PathPrefix(rootPath).Subrouter()
 
 	// Create project kind
 	//r.Handle("/api/{project}", authMiddleware.Handler(KindHandler(a))).Methods(http.MethodPost)
 
-	//r.HandleFunc("/api", a.GetKindDefinitions()).Methods(http.MethodGet)
+	r.Handle("/api", authMiddleware.Handler(a.GetKindDefinitions())).Methods(http.MethodGet)
+	r.Handle("/api/{kind}", authMiddleware.Handler(a.KindSchemaHandler())).Methods(http.MethodGet)
+	r.Handle("/openapi.json", authMiddleware.Handler(a.OpenAPIHandler(rootPath))).Methods(http.MethodGet)
 
 	// User authorization
+	r.HandleFunc("/.well-known/jwks.json", a.JWKSHandler()).Methods(http.MethodGet)
 	r.HandleFunc("/auth/login", a.AuthLoginHandler()).Methods(http.MethodPost)
 	r.HandleFunc("/auth/register", a.AuthRegistrationHandler()).Methods(http.MethodPost)
+	r.HandleFunc("/auth/oidc/{provider}", a.AuthOIDCHandler()).Methods(http.MethodGet)
+	r.HandleFunc("/auth/oidc/{provider}/callback", a.AuthOIDCCallbackHandler()).Methods(http.MethodGet)
+	r.HandleFunc("/auth/verify-email", a.AuthVerifyEmailHandler()).Methods(http.MethodPost)
+	r.HandleFunc("/auth/verify-email/send", a.AuthSendVerificationHandler()).Methods(http.MethodPost)
+	r.HandleFunc("/auth/reset-password", a.AuthResetPasswordHandler()).Methods(http.MethodPost)
+	r.HandleFunc("/auth/reset-password/send", a.AuthSendPasswordResetHandler()).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login/2fa", a.AuthTwoFactorLoginHandler()).Methods(http.MethodPost)
+	r.Handle("/auth/2fa/enroll", authMiddleware.Handler(a.AuthTwoFactorEnrollHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/2fa/confirm", authMiddleware.Handler(a.AuthTwoFactorConfirmHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/2fa/disable", authMiddleware.Handler(a.AuthTwoFactorDisableHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/refresh", authMiddleware.Handler(a.AuthRefreshHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/logout", authMiddleware.Handler(a.AuthLogoutHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/me", authMiddleware.Handler(a.AuthMeHandler())).Methods(http.MethodGet)
+	r.Handle("/auth/me", authMiddleware.Handler(a.AuthUpdateMeHandler())).Methods(http.MethodPatch)
+	r.Handle("/auth/me/password", authMiddleware.Handler(a.AuthPasswordHandler())).Methods(http.MethodPut)
+	r.Handle("/auth/users", authMiddleware.Handler(a.AuthUsersHandler())).Methods(http.MethodGet)
+	r.Handle("/auth/users/{email}", authMiddleware.Handler(a.AuthUserHandler())).Methods(http.MethodGet)
+	r.Handle("/auth/users/{email}/disabled", authMiddleware.Handler(a.AuthUserDisabledHandler())).Methods(http.MethodPut)
+	r.Handle("/auth/users/{email}/groups", authMiddleware.Handler(a.AuthUserGroupsHandler())).Methods(http.MethodPut)
+	r.Handle("/auth/users/{email}/sessions", authMiddleware.Handler(a.AuthUserSessionsHandler())).Methods(http.MethodDelete)
+	r.Handle("/auth/apikeys", authMiddleware.Handler(a.APIKeysHandler())).Methods(http.MethodGet)
+	r.Handle("/auth/apikeys", authMiddleware.Handler(a.AddAPIKeyHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/apikeys/{id}", authMiddleware.Handler(a.DeleteAPIKeyHandler())).Methods(http.MethodDelete)
+	r.Handle("/auth/project", authMiddleware.Handler(a.AuthProjectHandler())).Methods(http.MethodPost)
+	r.Handle("/projects", authMiddleware.Handler(a.ProjectsHandler())).Methods(http.MethodGet)
+	r.Handle("/projects", authMiddleware.Handler(a.AddProjectHandler())).Methods(http.MethodPost)
+	r.Handle("/projects/{project}", authMiddleware.Handler(a.ProjectHandler())).Methods(http.MethodGet)
+	r.Handle("/projects/{project}/members", authMiddleware.Handler(a.ProjectMembersHandler())).Methods(http.MethodGet)
+	r.Handle("/projects/{project}/members/{email}", authMiddleware.Handler(a.ProjectMemberRoleHandler())).Methods(http.MethodPut)
+	r.Handle("/projects/{project}/members/{email}", authMiddleware.Handler(a.RemoveProjectMemberHandler())).Methods(http.MethodDelete)
+	r.Handle("/projects/{project}/owner", authMiddleware.Handler(a.ProjectOwnerHandler())).Methods(http.MethodPut)
+	r.Handle("/projects/{project}/invitations", authMiddleware.Handler(a.ProjectInvitationsHandler())).Methods(http.MethodGet)
+	r.Handle("/projects/{project}/invitations", authMiddleware.Handler(a.InviteHandler())).Methods(http.MethodPost)
+	r.Handle("/projects/{project}/invitations/{email}", authMiddleware.Handler(a.CancelInvitationHandler())).Methods(http.MethodDelete)
+	r.Handle("/auth/invitations", authMiddleware.Handler(a.AuthInvitationsHandler())).Methods(http.MethodGet)
+	r.Handle("/auth/invitations/{project}", authMiddleware.Handler(a.AuthAcceptInvitationHandler())).Methods(http.MethodPost)
+	r.Handle("/auth/invitations/{project}", authMiddleware.Handler(a.AuthDeclineInvitationHandler())).Methods(http.MethodDelete)
+
+	r.Handle("/kinds", authMiddleware.Handler(a.RuntimeKindsHandler())).Methods(http.MethodGet)
+	r.Handle("/kinds", authMiddleware.Handler(a.AddRuntimeKindHandler())).Methods(http.MethodPost)
+	r.Handle("/kinds/{kind}", authMiddleware.Handler(a.UpdateRuntimeKindHandler())).Methods(http.MethodPut)
+	r.Handle("/kinds/{kind}", authMiddleware.Handler(a.DeleteRuntimeKindHandler())).Methods(http.MethodDelete)
+	r.Handle("/kinds/{kind}/migrations", authMiddleware.Handler(a.MigrateKindHandler())).Methods(http.MethodPost)
+
+	// API; kinds are looked up on each request so kinds defined at runtime get routes too
+	r.Handle("/{kind}", authMiddleware.Handler(a.kindRoute(a.ListHandler))).Methods(http.MethodGet)  // LIST
+	r.Handle("/{kind}", authMiddleware.Handler(a.kindRoute(a.AddHandler))).Methods(http.MethodPost)  // ADD
+	r.Handle("/{kind}/{id}", authMiddleware.Handler(a.kindRoute(a.GetHandler))).Methods(http.MethodGet) // GET
+	r.Handle("/{kind}/{id}", authMiddleware.Handler(a.kindRoute(func(e *kind.Kind) http.HandlerFunc {
+		return a.UpdateHandler(e, true)
+	}))).Methods(http.MethodPut) // REPLACE
+	r.Handle("/{kind}/{id}", authMiddleware.Handler(a.kindRoute(func(e *kind.Kind) http.HandlerFunc {
+		return a.UpdateHandler(e, false)
+	}))).Methods(http.MethodPatch) // UPDATE
+	r.Handle("/{kind}/{id}", authMiddleware.Handler(a.kindRoute(a.DeleteHandler))).Methods(http.MethodDelete) // DELETE
+
+	return r
+}
+
+// Authenticates request user and checks if user group has scope on kind.
+// When own is true the user can only access entries they created.
+// Returned context is in namespace of the active project.
+func (a *App) authorize(r *http.Request, e *kind.Kind, scope user.Scope) (ctx instance.Context, own bool, err error) {
+	_, ctx = instance.NewContext(r).Authenticate()
+	ctx, err = a.projectContext(ctx)
+	if err != nil {
+		return ctx, false, err
+	}
+	if a.rules == nil {
+		return ctx, false, nil
+	}
+	err = ctx.HasPermission(a.rules, e.Name, scope)
+	if err != nil && scope != user.Create && ctx.HasOwnPermission(a.rules, e.Name, scope) {
+		return ctx, true, nil
+	}
+	return ctx, false, err
+}
 
-	// API
-	for _, ent := range a.kinds {
-		name := strings.ToLower(ent.Name)
-		r.Handle("/"+name, authMiddleware.Handler(a.GetHandler(ent))).Methods(http.MethodGet)
+// Describes imported kinds in import order; fields caller's groups can't read are left out
+func (a *App) GetKindDefinitions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		ctx, err := a.projectContext(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
 
-		//r.Handle("/"+name+"/draft", authMiddleware.Handler(a.AddDraftHandler(ent))).Methods(http.MethodPost) // ADD
-		r.Handle("/"+name, authMiddleware.Handler(a.AddHandler(ent))).Methods(http.MethodPost)               // ADD
-		//r.Handle("/"+name+"/{id}", authMiddleware.Handler(a.KindGetHandler(e))).Methods(http.MethodGet)       // GET
-		//r.Handle("/{project}/api/"+name+"/{id}", authMiddleware.Handler(a.KindUpdateHandler(e))).Methods(http.MethodPut)    // UPDATE
-		//r.Handle("/{project}/api/"+name+"/{id}", authMiddleware.Handler(a.KindDeleteHandler(e))).Methods(http.MethodDelete) // DELETE
+		kinds, err := a.allKinds(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var defs = []*kind.Definition{}
+		for _, k := range kinds {
+			defs = append(defs, k.Definition(callerGroups(ctx)))
+		}
+
+		ctx.PrintResult(w, defs)
 	}
+}
 
-	http.Handle(rootPath, &Server{r})
+// Checks if entry was created by the context user
+func isOwner(ctx instance.Context, h *kind.Holder) bool {
+	createdBy := h.CreatedBy()
+	return createdBy != nil && ctx.UserKey != nil && createdBy.Equal(ctx.UserKey)
 }
 
+// Signs token setting its expiration to Options.TokenLifetime from now
 func (a *App) SignToken(token *jwt.Token) (*instance.Token, error) {
-	signedToken, err := token.SignedString(a.PrivateKey)
+	token.Claims.(jwt.MapClaims)["exp"] = time.Now().Add(a.Options.TokenLifetime).Unix()
+
+	signedToken, err := a.keys.SignToken(token)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// name used in Permissions for API key management, e.g. {"admin":["apikey:*"]}
+const apiKeyPermissionName = "apikey"
+
+// last use is written at most this often
+const apiKeyUsageResolution = time.Minute
+
+var errAPIKeyRevoked = errors.New("api key revoked")
+
+// Non-expiring credential for server to server access, stored as kind "APIKey".
+// Its token carries Permissions in "kind:scope" syntax and authenticates as "apikey:{id}",
+// which is also recorded as entry creator. Key accesses the project that was active when
+// it was created for as long as its creator is a project member. To change permissions create a new key.
+type APIKey struct {
+	Id          string    `datastore:"-" json:"id"`
+	Name        string    `datastore:"name,noindex" json:"name"`
+	Permissions []string  `datastore:"permissions,noindex" json:"permissions"`
+	Project     string    `datastore:"project" json:"project,omitempty"`
+	CreatedBy   string    `datastore:"createdBy" json:"createdBy"`
+	CreatedAt   time.Time `datastore:"createdAt" json:"createdAt"`
+	LastUsedAt  time.Time `datastore:"lastUsedAt,noindex" json:"lastUsedAt"`
+}
+
+// Response of API key creation; the token is shown only once
+type APIKeyResult struct {
+	Key   *APIKey `json:"key"`
+	Token string  `json:"token"`
+}
+
+func apiKeyKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, "APIKey", id, 0, nil)
+}
+
+// Rejects revoked keys, keys of disabled users and of users no longer members of the key project;
+// records key use
+func (a *App) useAPIKey(ctx context.Context, id string) error {
+	var k = new(APIKey)
+	err := a.Options.Store.Get(ctx, apiKeyKey(ctx, id), k)
+	if err != nil {
+		if err == store.ErrNoSuchEntity {
+			return errAPIKeyRevoked
+		}
+		return err
+	}
+	u, err := a.getUser(ctx, k.CreatedBy)
+	if err != nil {
+		if err == instance.ErrUserDoesNotExist {
+			return errAPIKeyRevoked
+		}
+		return err
+	}
+	if u.Disabled {
+		return errAPIKeyRevoked
+	}
+	if len(k.Project) > 0 {
+		if _, err := project.GetMember(ctx, a.Options.Store, k.Project, k.CreatedBy); err != nil {
+			if err == project.ErrNotMember {
+				return errAPIKeyRevoked
+			}
+			return err
+		}
+	}
+
+	if now := time.Now(); now.Sub(k.LastUsedAt) > apiKeyUsageResolution {
+		if err := a.recordAPIKeyUse(ctx, id, now); err != nil {
+			log.Printf("recording use of api key %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// Sets last use in a transaction so a key revoked meanwhile isn't stored again
+func (a *App) recordAPIKeyUse(ctx context.Context, id string, now time.Time) error {
+	return a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+		var k = new(APIKey)
+		err := a.Options.Store.Get(tc, apiKeyKey(tc, id), k)
+		if err == store.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k.LastUsedAt = now
+		_, err = a.Options.Store.Put(tc, apiKeyKey(tc, id), k)
+		return err
+	})
+}
+
+type apiKeyInput struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// Creates API key for {"name": "...", "permissions": ["post:read"]}; requires "apikey:create"
+// permission and caller can only grant permissions they have, including those of their project role.
+// Keys are created by users only so every key belongs to a user.
+func (a *App) AddAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if err := a.authorizeAction(ctx, apiKeyPermissionName, user.Create); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input apiKeyInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		input.Name = strings.TrimSpace(input.Name)
+		if len(input.Name) == 0 {
+			ctx.PrintError(w, instance.ErrInvalidFormInput)
+			return
+		}
+		// key is bound to the active project, so the user has to be its member; the key itself is stored
+		// in the default namespace
+		projectCtx, err := a.projectContext(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		for _, perm := range input.Permissions {
+			name, scope, err := user.ParsePermission(perm)
+			if err != nil {
+				ctx.PrintError(w, instance.NewError(err.Error(), instance.ErrInvalidPermission.Code))
+				return
+			}
+			if err := projectCtx.HasPermission(a.rules, name, scope); err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		id, err := randomToken(16)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		var k = &APIKey{
+			Id:          id,
+			Name:        input.Name,
+			Permissions: input.Permissions,
+			Project:     ctx.Project,
+			CreatedBy:   ctx.User,
+			CreatedAt:   time.Now(),
+		}
+		if k.Permissions == nil {
+			k.Permissions = []string{}
+		}
+
+		_, err = a.Options.Store.Put(ctx, apiKeyKey(ctx, id), k)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// no expiration; revoking the key stops the token from working
+		token, err := a.keys.SignToken(jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"aud":  instance.TokenAudience,
+			"iat":  k.CreatedAt.Unix(),
+			"sub":  apiKeyPermissionName + ":" + id,
+			"akid": id,
+			"scp":  k.Permissions,
+			"pro":  k.Project,
+		}))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusCreated, APIKeyResult{Key: k, Token: token})
+	}
+}
+
+// Lists API keys; requires "apikey:read" permission
+func (a *App) APIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, apiKeyPermissionName, user.Read); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		entities, _, err := a.Options.Store.Run(ctx, store.NewQuery("APIKey").Order("createdAt", false))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var keys = []*APIKey{}
+		for _, e := range entities {
+			var k = new(APIKey)
+			if err := datastore.LoadStruct(k, e.Properties); err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+			k.Id = e.Key.StringID()
+			keys = append(keys, k)
+		}
+
+		ctx.PrintResult(w, keys)
+	}
+}
+
+// Revokes API key; requires "apikey:delete" permission
+func (a *App) DeleteAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, apiKeyPermissionName, user.Delete); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		key := apiKeyKey(ctx, mux.Vars(r)["id"])
+		err := a.Options.Store.Get(ctx, key, new(APIKey))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.Options.Store.Delete(ctx, key)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
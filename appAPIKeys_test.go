@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/user"
+)
+
+// Serves App with kind "post"; group "admin" manages users and API keys, "editor" manages posts
+// and project owners manage posts of their projects
+func newAPIKeyTestServer(t *testing.T) *testServer {
+	s := newTestServer(t, Options{Permissions: user.Permissions{
+		"admin":  {"user:*", "apikey:*"},
+		"editor": {"post:*"},
+		"owner":  {"post:*"},
+	}})
+	if err := s.app.Import(kind.New("post", []*kind.Field{{Name: "title", Worker: &field.Text{}}})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Creates API key with permissions; returns key id and token
+func (s *testServer) createAPIKey(token string, permissions string) (string, string) {
+	var res = new(APIKeyResult)
+	if code := s.doJSON(http.MethodPost, "/auth/apikeys", `{"name":"build","permissions":`+permissions+`}`, token, res); code != http.StatusCreated {
+		s.t.Fatalf("creating api key with %s responded %d", permissions, code)
+	}
+	return res.Key.Id, res.Token
+}
+
+func TestAPIKey(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+	admin := s.registerInGroups("admin@example.com", "admin", "editor")
+	id, key := s.createAPIKey(admin.Token.Id, `["post:read","post:create"]`)
+
+	if code, _ := s.do(http.MethodPost, "/post", `{"title":"a"}`, key); code != http.StatusCreated {
+		t.Errorf("add with api key responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/post?key="+key, "", ""); code != http.StatusOK {
+		t.Errorf("list with api key parameter responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/users", "", key); code != http.StatusForbidden {
+		t.Errorf("api key without user permission responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/apikeys", `{"name":"copy","permissions":["post:read"]}`, key); code != http.StatusForbidden {
+		t.Errorf("creating api key with api key responded %d", code)
+	}
+
+	var keys []*APIKey
+	if code := s.doJSON(http.MethodGet, "/auth/apikeys", "", admin.Token.Id, &keys); code != http.StatusOK || len(keys) != 1 || keys[0].Id != id {
+		t.Errorf("list responded %d %v", code, keys)
+	}
+
+	if code, _ := s.do(http.MethodDelete, "/auth/apikeys/"+id, "", admin.Token.Id); code != http.StatusNoContent {
+		t.Fatalf("revoke responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/post", "", key); code != http.StatusUnauthorized {
+		t.Errorf("revoked api key responded %d", code)
+	}
+}
+
+// Keys can't have permissions their creator lacks
+func TestAPIKeyPermissions(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+	admin := s.registerInGroups("admin@example.com", "admin")
+
+	for _, permissions := range []string{`["post:read"]`, `["post:bad"]`, `["*:*"]`} {
+		if code, _ := s.do(http.MethodPost, "/auth/apikeys", `{"name":"build","permissions":`+permissions+`}`, admin.Token.Id); code == http.StatusCreated {
+			t.Errorf("creating api key with %s responded %d", permissions, code)
+		}
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/apikeys", `{"name":"build","permissions":["user:read"]}`, s.register("user@example.com").Token.Id); code != http.StatusForbidden {
+		t.Errorf("creating api key without apikey permission responded %d", code)
+	}
+
+	// project roles grant permissions within the project
+	s.createProject(admin.Token.Id, "acme")
+	projectToken := s.switchProject(admin.Token.Id, "acme")
+	_, key := s.createAPIKey(projectToken, `["post:read","post:create"]`)
+	if code, _ := s.do(http.MethodPost, "/post", `{"title":"a"}`, key); code != http.StatusCreated {
+		t.Errorf("add with project api key responded %d", code)
+	}
+	var list map[string]interface{}
+	if code := s.doJSON(http.MethodGet, "/post", "", projectToken, &list); code != http.StatusOK {
+		t.Errorf("list in project responded %d", code)
+	} else if entries, _ := list["entries"].([]interface{}); len(entries) != 1 {
+		t.Errorf("list in project responded %v", list)
+	}
+}
+
+// Keys stop working once their creator is disabled
+func TestAPIKeyOfDisabledUser(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+	admin := s.registerInGroups("admin@example.com", "admin")
+	creator := s.registerInGroups("creator@example.com", "admin", "editor")
+	_, key := s.createAPIKey(creator.Token.Id, `["post:read"]`)
+
+	if code, _ := s.do(http.MethodGet, "/post", "", key); code != http.StatusOK {
+		t.Fatalf("list with api key responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPut, "/auth/users/creator@example.com/disabled", `{"disabled":true}`, admin.Token.Id); code != http.StatusOK {
+		t.Fatalf("disable responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/post", "", key); code != http.StatusUnauthorized {
+		t.Errorf("api key of disabled user responded %d", code)
+	}
+}
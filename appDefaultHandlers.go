@@ -10,18 +10,25 @@ import (
 	"github.com/ales6164/go-cms/user"
 	"github.com/ales6164/go-cms/project"
 	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/store"
+	"github.com/gorilla/mux"
+	"github.com/dgrijalva/jwt-go"
+	"log"
 )
 
-func (a *App) AuthLoginHandler() http.HandlerFunc {
-	type Input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+// name used in Permissions for user management, e.g. {"admin":["user:*"]}
+const userPermissionName = "user"
 
+type loginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (a *App) AuthLoginHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := instance.NewContext(r)
 
-		var input Input
+		var input loginInput
 		err := json.Unmarshal(ctx.Body(), &input)
 		if err != nil {
 			ctx.PrintError(w, err)
@@ -40,12 +47,25 @@ func (a *App) AuthLoginHandler() http.HandlerFunc {
 			return
 		}
 
+		// refuse locked out accounts and IP addresses before checking the password
+		ip := a.remoteIP(r)
+		lockout, err := a.loginLockout(ctx, input.Email, ip)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if lockout > 0 {
+			printLoginLocked(ctx, w, lockout)
+			return
+		}
+
 		// get user
 		userKey := datastore.NewKey(ctx, "User", input.Email, 0, nil)
 		user := new(user.User)
-		err = datastore.Get(ctx, userKey, user)
+		err = a.Options.Store.Get(ctx, userKey, user)
 		if err != nil {
-			if err == datastore.ErrNoSuchEntity {
+			if err == store.ErrNoSuchEntity {
+				a.loginFailed(ctx, input.Email, ip, "user does not exist")
 				ctx.PrintError(w, instance.ErrUserDoesNotExist)
 				return
 			}
@@ -56,41 +76,59 @@ func (a *App) AuthLoginHandler() http.HandlerFunc {
 		// decrypt hash
 		err = decrypt(user.Hash, []byte(input.Password))
 		if err != nil {
+			a.loginFailed(ctx, input.Email, ip, "password incorrect")
 			ctx.PrintError(w, instance.ErrUserPasswordIncorrect)
-			// todo: log and report
 			return
 		}
 
-		// get user projects
-		user.Projects, _ = project.GetUserProjects(ctx, userKey)
+		if user.Disabled {
+			ctx.PrintError(w, instance.ErrUserDisabled)
+			return
+		}
+		if a.Options.RequireEmailVerification && !user.EmailVerified {
+			ctx.PrintError(w, instance.ErrEmailNotVerified)
+			return
+		}
 
-		// create a token
-		token := instance.NewToken(user.Email, "")
+		// failed attempts are cleared once the second step succeeds
+		if user.TOTPEnabled {
+			a.printTwoFactorChallenge(ctx, w, user)
+			return
+		}
 
-		// sign the new token
-		signedToken, err := a.SignToken(token)
+		err = a.resetLoginAttempts(ctx, input.Email)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
-		ctx.PrintAuth(w, user, signedToken)
+		// get user projects
+		user.Projects, _ = project.GetUserProjects(ctx, a.Options.Store, userKey)
+
+		// create a session with signed access token and refresh token
+		signedToken, refreshToken, err := a.newSession(ctx, user, false)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintAuth(w, user, signedToken, refreshToken)
 	}
 }
 
-func (a *App) AuthRegistrationHandler() http.HandlerFunc {
-	type Input struct {
-		Email     string `json:"email"`
-		Password  string `json:"password"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Photo     string `json:"photo"`
-	}
+type registrationInput struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	Photo     string `json:"photo,omitempty"`
+}
 
+func (a *App) AuthRegistrationHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := instance.NewContext(r)
 
-		var input Input
+		var input registrationInput
 		err := json.Unmarshal(ctx.Body(), &input)
 		if err != nil {
 			ctx.PrintError(w, err)
@@ -129,34 +167,257 @@ func (a *App) AuthRegistrationHandler() http.HandlerFunc {
 			LastName:  input.LastName,
 		}
 
-		err = datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		err = a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
 			userKey := datastore.NewKey(tc, "User", user.Email, 0, nil)
-			err := datastore.Get(tc, userKey, &datastore.PropertyList{})
+			err := a.Options.Store.Get(tc, userKey, &datastore.PropertyList{})
 			if err != nil {
-				if err == datastore.ErrNoSuchEntity {
+				if err == store.ErrNoSuchEntity {
 					// register
-					_, err := datastore.Put(tc, userKey, user)
+					_, err := a.Options.Store.Put(tc, userKey, user)
 					return err
 				}
 				return err
 			}
 			return instance.ErrUserAlreadyExists
-		}, nil)
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// registration succeeded even if sending fails; the link can be requested again
+		err = a.SendVerificationEmail(ctx, user)
+		if err != nil {
+			log.Printf("sending verification email to %s: %v", user.Email, err)
+		}
+		if a.Options.RequireEmailVerification {
+			ctx.PrintAuth(w, user, nil, nil)
+			return
+		}
+
+		// create a session with signed access token and refresh token
+		signedToken, refreshToken, err := a.newSession(ctx, user, false)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
-		// create a token
-		token := instance.NewToken(user.Email, "")
+		ctx.PrintAuth(w, user, signedToken, refreshToken)
+	}
+}
+
+type refreshInput struct {
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// Issues a new access token for a refresh token given as {"refreshToken": "..."} or, without one,
+// for a valid access token or one expired less than instance.RenewGracePeriod ago whose session hasn't expired.
+// User groups and project membership are read again so changes are picked up.
+func (a *App) AuthRefreshHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, token := instance.NewContext(r).Renew()
+
+		var input refreshInput
+		if body := ctx.Body(); len(body) > 0 {
+			err := json.Unmarshal(body, &input)
+			if err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		if len(input.RefreshToken) > 0 {
+			id := sessionId(input.RefreshToken)
+			s, err := a.getSession(ctx, id)
+			if err != nil {
+				if err == errSessionRevoked {
+					err = instance.ErrUnathorized
+				}
+				ctx.PrintError(w, err)
+				return
+			}
+			ctx.User = s.User
+			ctx.Session = id
+			ctx.TwoFactor = s.TwoFactor
+			ctx.Project = s.Project
+			token = instance.NewToken(s.User, s.Project, nil)
+			token.Claims.(jwt.MapClaims)["sid"] = id
+			if s.TwoFactor {
+				token.Claims.(jwt.MapClaims)["mfa"] = true
+			}
+		}
+
+		if token == nil {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+		// renewed access tokens can't outlive their session
+		if len(input.RefreshToken) == 0 {
+			if len(ctx.Session) == 0 {
+				ctx.PrintError(w, instance.ErrUnathorized)
+				return
+			}
+			if _, err := a.getSession(ctx, ctx.Session); err != nil {
+				if err == errSessionRevoked {
+					err = instance.ErrUnathorized
+				}
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		userKey := datastore.NewKey(ctx, "User", ctx.User, 0, nil)
+		user := new(user.User)
+		err := a.Options.Store.Get(ctx, userKey, user)
+		if err != nil {
+			if err == store.ErrNoSuchEntity {
+				ctx.PrintError(w, instance.ErrUnathorized)
+				return
+			}
+			ctx.PrintError(w, err)
+			return
+		}
+		if user.Disabled {
+			ctx.PrintError(w, instance.ErrUserDisabled)
+			return
+		}
+		token.Claims.(jwt.MapClaims)["grp"] = a.tokenGroups(user, ctx.TwoFactor)
+		projectNamespace, err := a.tokenProject(ctx, user, ctx.Project)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		token.Claims.(jwt.MapClaims)["pro"] = projectNamespace
 
-		// sign the new token
 		signedToken, err := a.SignToken(token)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
-		ctx.PrintAuth(w, user, signedToken)
+		ctx.PrintResult(w, signedToken)
+	}
+}
+
+// Sets user groups; changes are included in tokens issued after the call
+func (a *App) SetUserGroups(ctx context.Context, email string, groups []string) (*user.User, error) {
+	for _, group := range groups {
+		if !validGroup(group) {
+			return nil, instance.ErrInvalidGroup
+		}
+	}
+
+	return a.updateUser(ctx, email, func(u *user.User) error {
+		u.Groups = groups
+		return nil
+	})
+}
+
+// Group names can be used in Permissions; project roles are group names too
+func validGroup(group string) bool {
+	return len(group) > 0 && !strings.Contains(group, ":") && group != user.PublicGroup
+}
+
+// Loads user, applies update and stores it in a transaction; update error cancels the change
+func (a *App) updateUser(ctx context.Context, email string, update func(u *user.User) error) (*user.User, error) {
+	var u *user.User
+	err := a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+		u = new(user.User)
+		userKey := datastore.NewKey(tc, "User", strings.ToLower(email), 0, nil)
+		err := a.Options.Store.Get(tc, userKey, u)
+		if err != nil {
+			if err == store.ErrNoSuchEntity {
+				return instance.ErrUserDoesNotExist
+			}
+			return err
+		}
+		if err = update(u); err != nil {
+			return err
+		}
+		_, err = a.Options.Store.Put(tc, userKey, u)
+		return err
+	})
+	return u, err
+}
+
+// User groups included in access tokens; groups in Options.RequireTwoFactor are left out
+// unless user logged in with two-factor authentication
+func (a *App) tokenGroups(u *user.User, twoFactor bool) []string {
+	return a.twoFactorGroups(u.Groups, twoFactor)
+}
+
+// Leaves out groups in Options.RequireTwoFactor unless user logged in with two-factor authentication
+func (a *App) twoFactorGroups(userGroups []string, twoFactor bool) []string {
+	if twoFactor || len(a.Options.RequireTwoFactor) == 0 {
+		return userGroups
+	}
+	var groups []string
+	for _, group := range userGroups {
+		var required bool
+		for _, r := range a.Options.RequireTwoFactor {
+			if group == r {
+				required = true
+				break
+			}
+		}
+		if !required {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// Checks if authenticated user has "user:update" permission
+func (a *App) authorizeUserManagement(ctx instance.Context) error {
+	return a.authorizeAction(ctx, userPermissionName, user.Update)
+}
+
+// Checks if authenticated user has scope on a built-in resource such as users;
+// without configured permissions nobody has it
+func (a *App) authorizeAction(ctx instance.Context, name string, scope user.Scope) error {
+	if !ctx.IsAuthenticated {
+		return instance.ErrUnathorized
+	}
+	if a.rules == nil {
+		return instance.ErrForbidden
+	}
+	return ctx.HasPermission(a.rules, name, scope)
+}
+
+type userGroupsInput struct {
+	Groups []string `json:"groups"`
+}
+
+// Assigns groups to a user; requires "user:update" permission
+func (a *App) AuthUserGroupsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeUserManagement(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input userGroupsInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		u, err := a.SetUserGroups(ctx, mux.Vars(r)["email"], input.Groups)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, u)
+	}
+}
+
+// Serves public keys verifying tokens; HMAC keys are not published
+func (a *App) JWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+		ctx.PrintResult(w, a.keys.JWKS())
 	}
 }
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/mail"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// token audiences; a token issued for one flow can't be used in another or as access token
+const (
+	verifyEmailAudience   = "verify-email"
+	resetPasswordAudience = "reset-password"
+)
+
+var (
+	VerifyEmailTokenLifetime   = time.Hour * 48
+	ResetPasswordTokenLifetime = time.Hour
+)
+
+// Fingerprint of the password hash carried in reset tokens; once the password
+// changes the token no longer matches, which makes it single use
+func passwordFingerprint(hash []byte) string {
+	sum := sha256.Sum256(hash)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Signs a token for the email flow given by audience
+func (a *App) newEmailToken(u *user.User, audience string, lifetime time.Duration) (string, error) {
+	var now = time.Now()
+	var claims = jwt.MapClaims{
+		"aud": audience,
+		"sub": u.Email,
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+	}
+	if audience == resetPasswordAudience {
+		claims["pwd"] = passwordFingerprint(u.Hash)
+	}
+	return a.keys.SignToken(jwt.NewWithClaims(jwt.SigningMethodHS256, claims))
+}
+
+// Verifies token signature, expiration and audience; returns token claims
+func (a *App) parseToken(tokenString string, audience string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, a.keys.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, instance.ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyAudience(audience, true) {
+		return nil, instance.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (a *App) parseEmailToken(tokenString string, audience string) (jwt.MapClaims, error) {
+	claims, err := a.parseToken(tokenString, audience)
+	if err != nil {
+		return nil, err
+	}
+	if sub, ok := claims["sub"].(string); !ok || len(sub) == 0 {
+		return nil, instance.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Appends token to the page URL; without a page only the token is returned
+func tokenLink(page string, token string) string {
+	if len(page) == 0 {
+		return token
+	}
+	return pageLink(page, "token", token)
+}
+
+// Appends query parameter to the page URL
+func pageLink(page string, name string, value string) string {
+	sep := "?"
+	if strings.Contains(page, "?") {
+		sep = "&"
+	}
+	return page + sep + name + "=" + url.QueryEscape(value)
+}
+
+// Sends user a link to verify their email
+func (a *App) SendVerificationEmail(ctx context.Context, u *user.User) error {
+	token, err := a.newEmailToken(u, verifyEmailAudience, VerifyEmailTokenLifetime)
+	if err != nil {
+		return err
+	}
+	return a.Options.Mailer.Send(ctx, &mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email",
+		Body:    "Open the link below to verify your email:\n\n" + tokenLink(a.Options.VerifyEmailURL, token),
+	})
+}
+
+// Sends user a link to reset their password
+func (a *App) SendPasswordResetEmail(ctx context.Context, u *user.User) error {
+	token, err := a.newEmailToken(u, resetPasswordAudience, ResetPasswordTokenLifetime)
+	if err != nil {
+		return err
+	}
+	return a.Options.Mailer.Send(ctx, &mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body:    "Open the link below to set a new password:\n\n" + tokenLink(a.Options.ResetPasswordURL, token),
+	})
+}
+
+type emailInput struct {
+	Email string `json:"email"`
+}
+
+// Reads {"email": "..."} input and loads the user; nil user is returned if it doesn't exist
+func (a *App) emailInputUser(ctx instance.Context) (*user.User, error) {
+	var input emailInput
+	err := json.Unmarshal(ctx.Body(), &input)
+	if err != nil {
+		return nil, err
+	}
+
+	var u = new(user.User)
+	err = a.Options.Store.Get(ctx, datastore.NewKey(ctx, "User", strings.ToLower(input.Email), 0, nil), u)
+	if err == store.ErrNoSuchEntity {
+		return nil, nil
+	}
+	return u, err
+}
+
+// Sends verification link for {"email": "..."}; responds the same whether the user exists or not
+func (a *App) AuthSendVerificationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		u, err := a.emailInputUser(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		if u != nil && !u.EmailVerified {
+			err = a.SendVerificationEmail(ctx, u)
+			if err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+type tokenInput struct {
+	Token string `json:"token"`
+}
+
+// Marks user email verified for {"token": "..."}
+func (a *App) AuthVerifyEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		var input tokenInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		claims, err := a.parseEmailToken(input.Token, verifyEmailAudience)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var u = new(user.User)
+		err = a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+			userKey := datastore.NewKey(tc, "User", claims["sub"].(string), 0, nil)
+			err := a.Options.Store.Get(tc, userKey, u)
+			if err != nil {
+				if err == store.ErrNoSuchEntity {
+					return instance.ErrInvalidToken
+				}
+				return err
+			}
+			if u.EmailVerified {
+				return nil
+			}
+			u.EmailVerified = true
+			_, err = a.Options.Store.Put(tc, userKey, u)
+			return err
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, u)
+	}
+}
+
+// Sends password reset link for {"email": "..."}; responds the same whether the user exists or not
+func (a *App) AuthSendPasswordResetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		u, err := a.emailInputUser(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		if u != nil {
+			err = a.SendPasswordResetEmail(ctx, u)
+			if err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+type resetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Sets new password for {"token": "...", "password": "..."} and revokes all user sessions.
+// Receiving the link proves email ownership so email is marked verified as well.
+func (a *App) AuthResetPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		var input resetPasswordInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		claims, err := a.parseEmailToken(input.Token, resetPasswordAudience)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if len(input.Password) < 6 || len(input.Password) > 128 {
+			ctx.PrintError(w, instance.ErrPasswordLength)
+			return
+		}
+
+		hash, err := crypt([]byte(input.Password))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var email = claims["sub"].(string)
+		var fingerprint, _ = claims["pwd"].(string)
+		err = a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+			userKey := datastore.NewKey(tc, "User", email, 0, nil)
+			u := new(user.User)
+			err := a.Options.Store.Get(tc, userKey, u)
+			if err != nil {
+				if err == store.ErrNoSuchEntity {
+					return instance.ErrInvalidToken
+				}
+				return err
+			}
+			if subtle.ConstantTimeCompare([]byte(fingerprint), []byte(passwordFingerprint(u.Hash))) != 1 {
+				return instance.ErrInvalidToken
+			}
+			u.Hash = hash
+			u.EmailVerified = true
+			_, err = a.Options.Store.Put(tc, userKey, u)
+			return err
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.RevokeSessions(ctx, email)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// user proved email ownership so account lockout no longer applies
+		err = a.resetLoginAttempts(ctx, email)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Token from the link in the last message sent to the address
+func (s *testServer) mailedToken(to string) string {
+	msg := s.mailer.last(to)
+	if msg == nil {
+		s.t.Fatalf("no message sent to %s", to)
+	}
+	link, err := url.Parse(msg.Body[strings.LastIndex(msg.Body, "\n")+1:])
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s := newTestServer(t, Options{RequireEmailVerification: true, VerifyEmailURL: "https://example.com/verify"})
+	s.register("user@example.com")
+	token := s.mailedToken("user@example.com")
+
+	var login = `{"email":"user@example.com","password":"secret1"}`
+	if code, _ := s.do(http.MethodPost, "/auth/login", login, ""); code != http.StatusBadRequest {
+		t.Errorf("login before verification responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", token); code != http.StatusUnauthorized {
+		t.Errorf("verification token as access token responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password", `{"token":"`+token+`","password":"secret2"}`, ""); code != http.StatusBadRequest {
+		t.Errorf("verification token as reset token responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodPost, "/auth/verify-email", `{"token":"`+token+`"}`, ""); code != http.StatusOK {
+		t.Fatalf("verify responded %d", code)
+	}
+	s.login("user@example.com")
+
+	if code, _ := s.do(http.MethodPost, "/auth/verify-email/send", `{"email":"user@example.com"}`, ""); code != http.StatusNoContent {
+		t.Errorf("send to verified user responded %d", code)
+	}
+	if s.mailedToken("user@example.com") != token {
+		t.Error("verification link sent to verified user")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	s := newTestServer(t, Options{ResetPasswordURL: "https://example.com/reset"})
+	auth := s.register("user@example.com")
+
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password/send", `{"email":"nobody@example.com"}`, ""); code != http.StatusNoContent {
+		t.Errorf("send to unknown user responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password/send", `{"email":"User@example.com"}`, ""); code != http.StatusNoContent {
+		t.Fatalf("send responded %d", code)
+	}
+	token := s.mailedToken("user@example.com")
+
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password", `{"token":"`+token+`","password":"short"}`, ""); code != http.StatusBadRequest {
+		t.Errorf("reset to short password responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password", `{"token":"`+token+`","password":"secret2"}`, ""); code != http.StatusNoContent {
+		t.Fatalf("reset responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password", `{"token":"`+token+`","password":"secret3"}`, ""); code != http.StatusBadRequest {
+		t.Errorf("reused reset token responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusUnauthorized {
+		t.Errorf("session from before reset responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/login", `{"email":"user@example.com","password":"secret1"}`, ""); code != http.StatusBadRequest {
+		t.Errorf("login with old password responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/login", `{"email":"user@example.com","password":"secret2"}`, ""); code != http.StatusOK {
+		t.Errorf("login with new password responded %d", code)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"testing"
+
+	"github.com/ales6164/go-cms/signing"
+	"github.com/ales6164/go-cms/store"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func generateRSA(t *testing.T, id string) *signing.Key {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := signing.ParsePEM(id, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func generateECDSA(t *testing.T, id string) *signing.Key {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := signing.ParsePEM(id, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// Re-signs claims of a token with another key
+func resign(t *testing.T, token string, k *signing.Key) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := k.SignToken(jwt.NewWithClaims(k.Method, parsed.Claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// Tokens signed before rotation keep working while the old key is a verification key
+func TestSigningKeyRotation(t *testing.T) {
+	var db = store.NewMemory()
+	old := generateRSA(t, "old")
+	before := newTestServer(t, Options{Store: db, SigningKey: old})
+	auth := before.register("user@example.com")
+
+	current := generateECDSA(t, "current")
+	after := newTestServer(t, Options{Store: db, SigningKey: current, VerificationKeys: []*signing.Key{old}})
+	if code, _ := after.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusOK {
+		t.Errorf("token signed with verification key responded %d", code)
+	}
+	if code, _ := after.do(http.MethodGet, "/auth/me", "", after.login("user@example.com").Token.Id); code != http.StatusOK {
+		t.Errorf("token signed with signing key responded %d", code)
+	}
+
+	rotated := newTestServer(t, Options{Store: db, SigningKey: current})
+	if code, _ := rotated.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusUnauthorized {
+		t.Errorf("token signed with removed key responded %d", code)
+	}
+}
+
+// Tokens must name a known key and use its algorithm
+func TestTokenKeyMismatch(t *testing.T) {
+	s := newTestServer(t, Options{SigningKey: generateRSA(t, "rsa")})
+	auth := s.register("user@example.com")
+
+	for name, token := range map[string]string{
+		"unknown kid":   resign(t, auth.Token.Id, signing.NewHMAC("other", []byte("secret"))),
+		"wrong alg":     resign(t, auth.Token.Id, signing.NewHMAC("rsa", []byte("secret"))),
+		"alg none":      resign(t, auth.Token.Id, &signing.Key{Id: "rsa", Method: jwt.SigningMethodNone, Sign: jwt.UnsafeAllowNoneSignatureType}),
+		"another key":   resign(t, auth.Token.Id, generateRSA(t, "rsa")),
+		"without a kid": resign(t, auth.Token.Id, signing.NewHMAC("", []byte("secret"))),
+	} {
+		if code, _ := s.do(http.MethodGet, "/auth/me", "", token); code != http.StatusUnauthorized {
+			t.Errorf("token with %s responded %d", name, code)
+		}
+	}
+}
+
+// JWKS publishes public keys of signing and verification keys, never HMAC secrets
+func TestJWKS(t *testing.T) {
+	s := newTestServer(t, Options{
+		SigningKey:       generateECDSA(t, "current"),
+		VerificationKeys: []*signing.Key{generateRSA(t, "old"), signing.NewHMAC("shared", []byte("secret"))},
+	})
+
+	var set signing.JWKS
+	if code := s.doJSON(http.MethodGet, "/.well-known/jwks.json", "", "", &set); code != http.StatusOK {
+		t.Fatalf("jwks responded %d", code)
+	}
+	var keys = map[string]signing.JWK{}
+	for _, k := range set.Keys {
+		keys[k.Kid] = k
+	}
+	if k := keys["current"]; len(keys) != 2 || k.Kty != "EC" || k.Alg != "ES256" || k.Crv != "P-256" {
+		t.Errorf("jwks responded %+v", set.Keys)
+	}
+	if k := keys["old"]; k.Kty != "RSA" || k.Alg != "RS256" || len(k.N) == 0 || len(k.E) == 0 {
+		t.Errorf("jwks responded %+v", set.Keys)
+	}
+}
@@ -6,16 +6,47 @@ import (
 	"github.com/gorilla/mux"
 	"google.golang.org/appengine/datastore"
 	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"strings"
+	"strconv"
+	"encoding/json"
+	"time"
+	"fmt"
+	"net/url"
 )
 
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+type ListResult struct {
+	Entries []map[string]interface{} `json:"entries"`
+	Cursor  string                   `json:"cursor,omitempty"`
+}
+
+// decodes {id} route variable and makes sure the key belongs to the kind and the active project
+func decodeKindKey(ctx instance.Context, r *http.Request, e *kind.Kind) (*datastore.Key, error) {
+	key, err := datastore.DecodeKey(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, instance.ErrInvalidId
+	}
+	if key.Kind() != e.Name || key.Namespace() != ctx.Project {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return key, nil
+}
+
 func (a *App) GetHandler(e *kind.Kind) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := instance.NewContext(r)
-
-		vars := mux.Vars(r)
-		id := vars["id"]
+		ctx, own, err := a.authorize(r, e, user.Read)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
 
-		key, err := datastore.DecodeKey(id)
+		key, err := decodeKindKey(ctx, r, e)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
@@ -26,73 +57,159 @@ func (a *App) GetHandler(e *kind.Kind) http.HandlerFunc {
 			ctx.PrintError(w, err)
 			return
 		}
+		if own && !isOwner(ctx, h) {
+			ctx.PrintError(w, instance.ErrForbidden)
+			return
+		}
+		h.SetGroups(callerGroups(ctx))
 
 		ctx.PrintResult(w, h.Output())
 	}
 }
 
-func (a *App) AddHandler(e *kind.Kind) http.HandlerFunc {
+// Lists entries; supports query parameters:
+//   filter=name<op>value with op one of =, <, <=, >, >= (repeatable), e.g. filter=price>=10
+//   order=name,-name (minus for descending order)
+//   limit=20 (max 100)
+//   cursor=value returned by the previous page
+func (a *App) ListHandler(e *kind.Kind) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := instance.NewContext(r)
+		ctx, own, err := a.authorize(r, e, user.Read)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
 
-		h := e.NewHolder(ctx, ctx.UserKey)
-		h.ParseInput(ctx.Body())
+		q, err := parseListQuery(e, r, callerGroups(ctx))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if own {
+			q.Filter("meta.createdBy", "=", ctx.UserKey)
+		}
 
-		err := h.Add()
+		holders, cursor, err := e.List(ctx, q)
 		if err != nil {
+			if err == store.ErrInvalidCursor {
+				err = queryError("cursor is not valid")
+			}
 			ctx.PrintError(w, err)
 			return
 		}
 
-		ctx.PrintResult(w, h.Output())
+		var result = ListResult{Entries: []map[string]interface{}{}}
+		for _, h := range holders {
+			h.SetGroups(callerGroups(ctx))
+			result.Entries = append(result.Entries, h.Output())
+		}
+		if len(holders) == q.Limit {
+			result.Cursor = cursor
+		}
+
+		ctx.PrintResult(w, result)
 	}
 }
 
-/*
-
-func (a *App) KindUpdateHandler(k *kind.Kind) http.HandlerFunc {
+func (a *App) AddHandler(e *kind.Kind) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, h, err := NewContext(r).Parse(k)
+		ctx, _, err := a.authorize(r, e, user.Create)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		h := e.NewHolder(ctx, ctx.UserKey)
+		h.SetGroups(callerGroups(ctx))
+		err = parseInput(h, ctx.Body())
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
-		vars := mux.Vars(r)
-		id := vars["id"]
+		err = h.Add()
+		if err != nil {
+			ctx.PrintError(w, saveError(err))
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusCreated, h.Output())
+	}
+}
+
+// PUT replaces the whole entry; PATCH keeps stored values of fields missing from input
+func (a *App) UpdateHandler(e *kind.Kind, replace bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, own, err := a.authorize(r, e, user.Update)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
 
-		key, err := datastore.DecodeKey(id)
+		key, err := decodeKindKey(ctx, r, e)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
-		err = h.Update(key)
+		if own {
+			stored, err := e.Get(ctx, key)
+			if err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+			if !isOwner(ctx, stored) {
+				ctx.PrintError(w, instance.ErrForbidden)
+				return
+			}
+		}
+
+		h := e.NewHolder(ctx, ctx.UserKey)
+		h.SetGroups(callerGroups(ctx))
+		err = parseInput(h, ctx.Body())
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
+		if replace {
+			err = h.Replace(key)
+		} else {
+			err = h.Update(key)
+		}
+		if err != nil {
+			ctx.PrintError(w, saveError(err))
+			return
+		}
+
 		ctx.PrintResult(w, h.Output())
 	}
 }
 
-func (a *App) KindDeleteHandler(k *kind.Kind) http.HandlerFunc {
+func (a *App) DeleteHandler(e *kind.Kind) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, h, err := NewContext(r).Parse(k)
+		ctx, own, err := a.authorize(r, e, user.Delete)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
 
-		vars := mux.Vars(r)
-		id := vars["id"]
+		key, err := decodeKindKey(ctx, r, e)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
 
-		key, err := datastore.DecodeKey(id)
+		// make sure entry exists so missing entries respond with 404
+		h, err := e.Get(ctx, key)
 		if err != nil {
 			ctx.PrintError(w, err)
 			return
 		}
+		if own && !isOwner(ctx, h) {
+			ctx.PrintError(w, instance.ErrForbidden)
+			return
+		}
 
 		err = h.Delete(key)
 		if err != nil {
@@ -100,7 +217,160 @@ func (a *App) KindDeleteHandler(k *kind.Kind) http.HandlerFunc {
 			return
 		}
 
-		ctx.PrintResult(w, h.Output())
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+// user groups including the public group
+func callerGroups(ctx instance.Context) []string {
+	return append([]string{user.PublicGroup}, ctx.Groups...)
+}
+
+// parses holder input; writing to fields user can't write and keys from other projects are input errors
+func parseInput(h *kind.Holder, body []byte) error {
+	err := h.ParseInput(body)
+	if fe, ok := err.(*kind.FieldPermissionError); ok {
+		return instance.NewError(fe.Error(), instance.ErrFieldForbidden.Code)
+	}
+	if ke, ok := err.(*kind.KeyNamespaceError); ok {
+		return instance.NewError(ke.Error(), instance.ErrFieldKeyNamespace.Code)
+	}
+	return err
+}
+
+// holder save errors caused by input, e.g. missing required fields, are input errors
+func saveError(err error) error {
+	if fe, ok := err.(*kind.RequiredFieldError); ok {
+		return instance.NewError(fe.Error(), instance.ErrFieldRequired.Code)
+	}
+	return err
+}
+
+func queryError(msg string) error {
+	return instance.NewError(msg, instance.ErrInvalidQuery.Code)
+}
+
+// limit query parameter; defaults to defaultListLimit
+func listLimit(values url.Values) (int, error) {
+	limit := values.Get("limit")
+	if len(limit) == 0 {
+		return defaultListLimit, nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > maxListLimit {
+		return 0, queryError(fmt.Sprintf("limit must be a number between 1 and %d", maxListLimit))
+	}
+	return n, nil
+}
+
+func parseListQuery(e *kind.Kind, r *http.Request, groups []string) (*store.Query, error) {
+	var values = r.URL.Query()
+	var q = store.NewQuery(e.Name)
+
+	limit, err := listLimit(values)
+	if err != nil {
+		return q, err
+	}
+	q.Limit = limit
+	q.Cursor = values.Get("cursor")
+
+	for _, filter := range values["filter"] {
+		i := strings.IndexAny(filter, "=<>")
+		if i < 1 {
+			return q, queryError("filter '" + filter + "' must be in format name<op>value")
+		}
+		name, op := filter[:i], filter[i:i+1]
+		if op != "=" && i+1 < len(filter) && filter[i+1] == '=' {
+			op += "="
+		}
+
+		f, err := indexedField(e, name, groups)
+		if err != nil {
+			return q, err
+		}
+		value, err := filterValue(f, name, filter[i+len(op):])
+		if err != nil {
+			return q, err
+		}
+		q.Filter(name, op, value)
+	}
+
+	if order := values.Get("order"); len(order) > 0 {
+		for _, name := range strings.Split(order, ",") {
+			var descending = strings.HasPrefix(name, "-")
+			name = strings.TrimPrefix(name, "-")
+			if _, err := indexedField(e, name, groups); err != nil {
+				return q, err
+			}
+			q.Order(name, descending)
+		}
+	}
+
+	return q, nil
+}
+
+// returns kind field that can be used in filters and ordering; nil field is returned for meta fields.
+// Fields user groups can't read can't be used either.
+func indexedField(e *kind.Kind, name string, groups []string) (*kind.Field, error) {
+	switch name {
+	case "meta.createdAt", "meta.updatedAt", "meta.createdBy", "meta.updatedBy", "meta.version":
+		return nil, nil
+	}
+	f, ok := e.Field(name)
+	if !ok || !f.CanRead(groups) {
+		return nil, queryError("field '" + name + "' does not exist")
+	}
+	if f.NoIndex {
+		return nil, instance.NewError("field '"+name+"' is not indexed and can't be used to filter or order", instance.ErrFieldNotIndexed.Code)
+	}
+	return f, nil
+}
+
+// converts filter value to the type stored in datastore
+func filterValue(f *kind.Field, name string, raw string) (interface{}, error) {
+	var invalid = queryError("filter value for field '" + name + "' is not valid")
+
+	if f == nil {
+		switch name {
+		case "meta.createdAt", "meta.updatedAt":
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, invalid
+			}
+			return t, nil
+		case "meta.createdBy", "meta.updatedBy":
+			key, err := datastore.DecodeKey(raw)
+			if err != nil {
+				return nil, invalid
+			}
+			return key, nil
+		default:
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			return n, nil
+		}
+	}
+
+	// values can be given as JSON (numbers decode to float64 as they do in entry input);
+	// anything else is taken as a string
+	var value interface{} = raw
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(raw), &decoded); err == nil {
+		value = decoded
+	}
+
+	var input = value
+	if f.Multiple {
+		input = []interface{}{value}
+	}
+	props, err := f.Parse(input)
+	if err != nil {
+		return nil, queryError(err.Error())
+	}
+	if len(props) == 0 {
+		return nil, invalid
 	}
+	return props[0].Value, nil
 }
-*/
@@ -0,0 +1,469 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// name used in Permissions for managing kinds defined at runtime, e.g. {"admin":["kind:*"]}
+const kindPermissionName = "kind"
+
+// kinds can't take names of routes and built-in permission names
+var reservedKindNames = []string{"api", "auth", "kinds", "projects", kindPermissionName, userPermissionName, apiKeyPermissionName}
+
+// datastore kinds App stores its own entities as; kind routes would expose them
+var internalKindNames = []string{"User", "Session", "APIKey", "Identity", "LoginAttempts", "LoginFailure",
+	"KindSpec", "Project", "ProjectMember", "ProjectInvitation"}
+
+// Kind defined at runtime, stored as kind "KindSpec" with lowercase kind name as key name
+type runtimeKind struct {
+	Spec      []byte    `datastore:"spec,noindex"` // JSON encoded kind.Spec
+	CreatedBy string    `datastore:"createdBy,noindex"`
+	CreatedAt time.Time `datastore:"createdAt,noindex"`
+	UpdatedAt time.Time `datastore:"updatedAt,noindex"`
+}
+
+// Kinds defined at runtime are shared by all projects, so they're kept in the root namespace
+func runtimeKindKey(ctx context.Context, name string) *datastore.Key {
+	rootCtx, _ := appengine.Namespace(ctx, "")
+	return datastore.NewKey(rootCtx, "KindSpec", strings.ToLower(name), 0, nil)
+}
+
+// Route name of kind
+func kindPath(k *kind.Kind) string {
+	return strings.ToLower(k.Name)
+}
+
+// Returns compiled kind with the route name
+func (a *App) importedKind(name string) (*kind.Kind, bool) {
+	for _, k := range a.Kinds {
+		if kindPath(k) == name {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Returns kind for route name; kinds defined at runtime are reloaded every Options.KindRefreshInterval
+func (a *App) findKind(ctx context.Context, name string) (*kind.Kind, error) {
+	if k, ok := a.importedKind(name); ok {
+		return k, nil
+	}
+	if err := a.loadRuntimeKinds(ctx, false); err != nil {
+		return nil, err
+	}
+	a.mu.RLock()
+	k, ok := a.runtimeKinds[name]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return k, nil
+}
+
+// Compiled kinds followed by kinds defined at runtime ordered by name
+func (a *App) allKinds(ctx context.Context) ([]*kind.Kind, error) {
+	if err := a.loadRuntimeKinds(ctx, false); err != nil {
+		return nil, err
+	}
+	return a.loadedKinds(), nil
+}
+
+func (a *App) loadedKinds() []*kind.Kind {
+	var kinds = append([]*kind.Kind{}, a.Kinds...)
+	var runtime []*kind.Kind
+	a.mu.RLock()
+	for _, k := range a.runtimeKinds {
+		runtime = append(runtime, k)
+	}
+	a.mu.RUnlock()
+	sort.Slice(runtime, func(i, j int) bool { return runtime[i].Name < runtime[j].Name })
+	return append(kinds, runtime...)
+}
+
+// Loads kinds defined at runtime from root namespace of Store; stored kinds that no longer build, e.g. because
+// their worker type isn't registered anymore, are logged and left out
+func (a *App) loadRuntimeKinds(ctx context.Context, force bool) error {
+	a.mu.RLock()
+	fresh := a.runtimeKinds != nil && time.Since(a.runtimeLoadedAt) < a.Options.KindRefreshInterval
+	a.mu.RUnlock()
+	if fresh && !force {
+		return nil
+	}
+
+	rootCtx, err := appengine.Namespace(ctx, "")
+	if err != nil {
+		return err
+	}
+	entities, _, err := a.Options.Store.Run(rootCtx, store.NewQuery("KindSpec"))
+	if err != nil {
+		return err
+	}
+	var kinds = map[string]*kind.Kind{}
+	for _, e := range entities {
+		var rk = new(runtimeKind)
+		if err := datastore.LoadStruct(rk, e.Properties); err != nil {
+			return err
+		}
+		k, err := a.buildRuntimeKind(rk.Spec)
+		if err == nil {
+			err = a.addRuntimeKind(kinds, k)
+		}
+		if err != nil {
+			log.Printf("loading kind %s: %v", e.Key.StringID(), err)
+		}
+	}
+
+	a.mu.Lock()
+	a.runtimeKinds = kinds
+	a.runtimeLoadedAt = time.Now()
+	a.mu.Unlock()
+	return nil
+}
+
+// Rejects route names, built-in permission names and names of internal kinds compared case-insensitively;
+// used for compiled and runtime kinds
+func checkKindName(name string) error {
+	for _, reserved := range reservedKindNames {
+		if strings.EqualFold(name, reserved) {
+			return instance.NewError("kind name '"+name+"' is reserved", instance.ErrInvalidKind.Code)
+		}
+	}
+	for _, internal := range internalKindNames {
+		if strings.EqualFold(name, internal) {
+			return instance.NewError("kind name '"+name+"' is reserved", instance.ErrInvalidKind.Code)
+		}
+	}
+	return nil
+}
+
+func (a *App) buildRuntimeKind(data []byte) (*kind.Kind, error) {
+	var spec = new(kind.Spec)
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, err
+	}
+	if err := checkKindName(spec.Name); err != nil {
+		return nil, err
+	}
+	k, err := spec.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.initKind(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Adds kind defined at runtime with its sub kinds to kinds keyed by route name; sub kinds shared
+// with imported kinds are left out. Names taken by other kinds return ErrKindAlreadyExists.
+func (a *App) addRuntimeKind(kinds map[string]*kind.Kind, k *kind.Kind) error {
+	var all = append([]*kind.Kind{k}, k.SubKinds()...)
+	for _, e := range all {
+		if imported, ok := a.importedKind(kindPath(e)); ok && imported != e {
+			return instance.ErrKindAlreadyExists
+		}
+		if added, ok := kinds[kindPath(e)]; ok && added != e {
+			return instance.ErrKindAlreadyExists
+		}
+	}
+	for _, e := range all {
+		if _, ok := a.importedKind(kindPath(e)); !ok {
+			kinds[kindPath(e)] = e
+		}
+	}
+	return nil
+}
+
+// Resolves {kind} route variable and serves request with the kind handler
+func (a *App) kindRoute(handler func(e *kind.Kind) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+		e, err := a.findKind(ctx, strings.ToLower(mux.Vars(r)["kind"]))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		handler(e)(w, r)
+	}
+}
+
+// Reads kind.Spec input and checks it builds; name is the {kind} route variable when updating
+func (a *App) runtimeKindInput(ctx instance.Context, name string) (*kind.Kind, []byte, error) {
+	var spec = new(kind.Spec)
+	err := json.Unmarshal(ctx.Body(), spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(name) > 0 {
+		if len(spec.Name) == 0 {
+			spec.Name = name
+		}
+		if strings.ToLower(spec.Name) != name {
+			return nil, nil, instance.NewError("kind name can't be changed", instance.ErrInvalidKind.Code)
+		}
+	}
+
+	if err := checkKindName(spec.Name); err != nil {
+		return nil, nil, err
+	}
+	k, err := spec.New()
+	if err == nil {
+		err = a.initKind(k)
+	}
+	if err != nil {
+		return nil, nil, instance.NewError(err.Error(), instance.ErrInvalidKind.Code)
+	}
+
+	// kind and its sub kinds can't take names of other kinds; updated kind replaces its loaded version
+	if err := a.loadRuntimeKinds(ctx, false); err != nil {
+		return nil, nil, err
+	}
+	var kinds = map[string]*kind.Kind{}
+	a.mu.RLock()
+	for path, e := range a.runtimeKinds {
+		if path != name {
+			kinds[path] = e
+		}
+	}
+	a.mu.RUnlock()
+	if err := a.addRuntimeKind(kinds, k); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, data, nil
+}
+
+// Updates loaded kinds after kind defined at runtime is stored; after deleting, kinds are loaded
+// again so sub kinds only the deleted kind used are removed too
+func (a *App) setRuntimeKind(name string, k *kind.Kind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.runtimeKinds == nil {
+		return
+	}
+	delete(a.runtimeKinds, name)
+	if k == nil {
+		a.runtimeLoadedAt = time.Time{}
+		return
+	}
+	if err := a.addRuntimeKind(a.runtimeKinds, k); err != nil {
+		log.Printf("adding kind %s: %v", name, err)
+	}
+}
+
+// Lists specs of kinds defined at runtime; requires "kind:read" permission
+func (a *App) RuntimeKindsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, kindPermissionName, user.Read); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err := a.loadRuntimeKinds(ctx, true)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var specs = []*kind.Spec{}
+		for _, k := range a.loadedKinds()[len(a.Kinds):] {
+			specs = append(specs, k.Spec())
+		}
+
+		ctx.PrintResult(w, specs)
+	}
+}
+
+// Defines kind for kind.Spec input, e.g. {"name": "post", "fields": [{"name": "title", "type": "Text"}]};
+// field types are worker types registered with kind.RegisterWorker. Requires "kind:create" permission.
+func (a *App) AddRuntimeKindHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, kindPermissionName, user.Create); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		k, data, err := a.runtimeKindInput(ctx, "")
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+			key := runtimeKindKey(tc, k.Name)
+			err := a.Options.Store.Get(tc, key, new(runtimeKind))
+			if err == nil {
+				return instance.ErrKindAlreadyExists
+			}
+			if err != store.ErrNoSuchEntity {
+				return err
+			}
+			var now = time.Now()
+			_, err = a.Options.Store.Put(tc, key, &runtimeKind{Spec: data, CreatedBy: ctx.User, CreatedAt: now, UpdatedAt: now})
+			return err
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		a.setRuntimeKind(kindPath(k), k)
+
+		ctx.PrintStatus(w, http.StatusCreated, k.Spec())
+	}
+}
+
+// Replaces fields of kind defined at runtime; stored entries are kept as they are.
+// Requires "kind:update" permission.
+func (a *App) UpdateRuntimeKindHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, kindPermissionName, user.Update); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		k, data, err := a.runtimeKindInput(ctx, strings.ToLower(mux.Vars(r)["kind"]))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+			key := runtimeKindKey(tc, k.Name)
+			var rk = new(runtimeKind)
+			if err := a.Options.Store.Get(tc, key, rk); err != nil {
+				return err
+			}
+			rk.Spec = data
+			rk.UpdatedAt = time.Now()
+			_, err := a.Options.Store.Put(tc, key, rk)
+			return err
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		a.setRuntimeKind(kindPath(k), k)
+
+		ctx.PrintResult(w, k.Spec())
+	}
+}
+
+// Deletes kind defined at runtime; its routes stop working but stored entries are kept.
+// Requires "kind:delete" permission.
+func (a *App) DeleteRuntimeKindHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, kindPermissionName, user.Delete); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		name := strings.ToLower(mux.Vars(r)["kind"])
+		key := runtimeKindKey(ctx, name)
+		err := a.Options.Store.Get(ctx, key, new(runtimeKind))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.Options.Store.Delete(ctx, key)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		a.setRuntimeKind(name, nil)
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+type migrationInput struct {
+	Steps     []*kind.StepSpec `json:"steps"`
+	DryRun    bool             `json:"dryRun,omitempty"`
+	Cursor    string           `json:"cursor,omitempty"`
+	BatchSize int              `json:"batchSize,omitempty"`
+}
+
+// Runs one batch of kind migration steps over stored entries of the active project, e.g.
+// {"steps": [{"op": "rename", "from": ["name"], "to": ["title"]}], "dryRun": true}. Steps are applied
+// against the current kind fields, so the kind is updated first. The returned cursor continues the
+// migration with the next batch until done. Requires "kind:update" permission.
+func (a *App) MigrateKindHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		// kind administration is granted by user groups, not by project roles
+		if err := a.authorizeAction(ctx, kindPermissionName, user.Update); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		e, err := a.findKind(ctx, strings.ToLower(mux.Vars(r)["kind"]))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx, err = a.projectContext(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input = new(migrationInput)
+		err = json.Unmarshal(ctx.Body(), input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var m = &kind.Migration{Kind: e, BatchSize: input.BatchSize, DryRun: input.DryRun}
+		for _, spec := range input.Steps {
+			if spec == nil {
+				ctx.PrintError(w, instance.NewError("migration step can't be null", instance.ErrInvalidMigration.Code))
+				return
+			}
+			step, err := spec.Step()
+			if err != nil {
+				ctx.PrintError(w, instance.NewError(err.Error(), instance.ErrInvalidMigration.Code))
+				return
+			}
+			m.Steps = append(m.Steps, step)
+		}
+		if len(m.Steps) == 0 {
+			ctx.PrintError(w, instance.ErrInvalidMigration)
+			return
+		}
+
+		progress, err := m.RunBatch(ctx, input.Cursor)
+		if err != nil {
+			if err == store.ErrInvalidCursor {
+				err = queryError("cursor is not valid")
+			}
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, progress)
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/signing"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+)
+
+// Compiled kinds can't take names of built-in permissions or internal kinds
+func TestImportReservedNames(t *testing.T) {
+	a := NewApp(Options{Store: store.NewMemory(), SigningKey: signing.NewHMAC("test", []byte("secret"))})
+	for _, name := range []string{"user", "User", "apikey", "APIKey", "kind", "session", "Auth"} {
+		err := a.Import(kind.New(name, []*kind.Field{{Name: "title", Worker: &field.Text{}}}))
+		if err == nil {
+			t.Errorf("kind %q was imported", name)
+		}
+	}
+	if len(a.Kinds) != 0 {
+		t.Errorf("got %d kinds", len(a.Kinds))
+	}
+}
+
+// Serves App where group "admin" manages kinds and their entries
+func newKindsTestServer(t *testing.T, opts Options) *testServer {
+	opts.Permissions = user.Permissions{"admin": {"kind:*", "post:*", "article:*", "book:*"}}
+	s := newTestServer(t, opts)
+	if err := s.app.Import(kind.New("post", []*kind.Field{{Name: "title", Worker: &field.Text{}}})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRuntimeKinds(t *testing.T) {
+	s := newKindsTestServer(t, Options{})
+	admin := s.registerInGroups("admin@example.com", "admin")
+
+	if code, _ := s.do(http.MethodPost, "/kinds", `{"name":"article","fields":[{"name":"title","type":"Text"}]}`, ""); code != http.StatusUnauthorized {
+		t.Errorf("anonymous add responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/kinds", `{"name":"article","fields":[{"name":"title","type":"Text"}]}`, s.register("user@example.com").Token.Id); code != http.StatusForbidden {
+		t.Errorf("add without permission responded %d", code)
+	}
+	for _, spec := range []string{
+		`{"name":"article","fields":[{"name":"title","type":"Nope"}]}`,
+		`{"name":"post","fields":[{"name":"title","type":"Text"}]}`,
+		`{"name":"auth","fields":[{"name":"title","type":"Text"}]}`,
+		`{"name":"session","fields":[{"name":"title","type":"Text"}]}`,
+	} {
+		if code, _ := s.do(http.MethodPost, "/kinds", spec, admin.Token.Id); code != http.StatusBadRequest {
+			t.Errorf("add %s responded %d", spec, code)
+		}
+	}
+
+	var spec = `{"name":"article","fields":[{"name":"title","type":"Text","required":true},{"name":"author.name","type":"Text"}]}`
+	if code, _ := s.do(http.MethodPost, "/kinds", spec, admin.Token.Id); code != http.StatusCreated {
+		t.Fatalf("add responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/kinds", strings.Replace(spec, "article", "Article", 1), admin.Token.Id); code != http.StatusBadRequest {
+		t.Errorf("adding kind again responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodPost, "/article", `{"author":{"name":"a"}}`, admin.Token.Id); code != http.StatusBadRequest {
+		t.Errorf("add entry without required field responded %d", code)
+	}
+	var added map[string]interface{}
+	if code := s.doJSON(http.MethodPost, "/article", `{"title":"a","author":{"name":"a"}}`, admin.Token.Id, &added); code != http.StatusCreated {
+		t.Fatalf("add entry responded %d", code)
+	}
+	id, _ := added["id"].(string)
+
+	if code, _ := s.do(http.MethodPut, "/kinds/article", `{"name":"other","fields":[{"name":"title","type":"Text"}]}`, admin.Token.Id); code != http.StatusBadRequest {
+		t.Errorf("renaming kind responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPut, "/kinds/article", `{"fields":[{"name":"title","type":"Text"},{"name":"summary","type":"Text"}]}`, admin.Token.Id); code != http.StatusOK {
+		t.Fatalf("update responded %d", code)
+	}
+	var specs []*kind.Spec
+	if code := s.doJSON(http.MethodGet, "/kinds", "", admin.Token.Id, &specs); code != http.StatusOK || len(specs) != 1 || len(specs[0].Fields) != 2 || specs[0].Fields[1].Name != "summary" {
+		t.Errorf("list responded %d %v", code, specs)
+	}
+
+	// other instances sharing the store pick the kind up
+	other := newKindsTestServer(t, Options{Store: s.app.Options.Store})
+	if code, _ := other.do(http.MethodGet, "/article/"+id, "", admin.Token.Id); code != http.StatusOK {
+		t.Errorf("get on another instance responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodDelete, "/kinds/article", "", admin.Token.Id); code != http.StatusNoContent {
+		t.Fatalf("delete responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/article/"+id, "", admin.Token.Id); code != http.StatusNotFound {
+		t.Errorf("get entry of deleted kind responded %d", code)
+	}
+	if code, _ := s.do(http.MethodDelete, "/kinds/article", "", admin.Token.Id); code != http.StatusNotFound {
+		t.Errorf("deleting again responded %d", code)
+	}
+}
+
+// Lists entries of kind in key order
+func (s *testServer) listEntries(token string, path string) []map[string]interface{} {
+	var list struct {
+		Entries []map[string]interface{} `json:"entries"`
+	}
+	if code := s.doJSON(http.MethodGet, path, "", token, &list); code != http.StatusOK {
+		s.t.Fatalf("list %s responded %d", path, code)
+	}
+	return list.Entries
+}
+
+func (s *testServer) migrate(token string, input string) (int, *kind.Progress) {
+	var progress = new(kind.Progress)
+	return s.doJSON(http.MethodPost, "/kinds/book/migrations", input, token, progress), progress
+}
+
+func TestKindMigration(t *testing.T) {
+	s := newKindsTestServer(t, Options{})
+	admin := s.registerInGroups("admin@example.com", "admin")
+	if code, _ := s.do(http.MethodPost, "/kinds", `{"name":"book","fields":[{"name":"name"},{"name":"author"},{"name":"tag"},{"name":"pages"}]}`, admin.Token.Id); code != http.StatusCreated {
+		t.Fatalf("add kind responded %d", code)
+	}
+	for _, entry := range []string{
+		`{"name":"A","author":"Ann Lee","tag":"x","pages":"12"}`,
+		`{"name":"B","author":"Bob","pages":"many"}`,
+		`{"name":"C"}`,
+	} {
+		if code, _ := s.do(http.MethodPost, "/book", entry, admin.Token.Id); code != http.StatusCreated {
+			t.Fatalf("add entry responded %d", code)
+		}
+	}
+	if code, _ := s.do(http.MethodPut, "/kinds/book", `{"fields":[{"name":"title"},{"name":"first"},{"name":"last"},{"name":"tag","multiple":true},{"name":"pages"},{"name":"status"}]}`, admin.Token.Id); code != http.StatusOK {
+		t.Fatalf("update kind responded %d", code)
+	}
+	steps := `[{"op":"rename","from":["name"],"to":["title"]},` +
+		`{"op":"split","from":["author"],"to":["first","last"],"separator":" "},` +
+		`{"op":"type","field":"tag"},` +
+		`{"op":"type","field":"pages","type":"int"},` +
+		`{"op":"default","field":"status","value":"draft"}]`
+
+	// dry run reports changes without storing them
+	code, progress := s.migrate(admin.Token.Id, `{"dryRun":true,"steps":`+steps+`}`)
+	if code != http.StatusOK || !progress.DryRun || !progress.Done || progress.Read != 3 || progress.Changed != 2 || len(progress.Failed) != 1 {
+		t.Fatalf("dry run responded %d %+v", code, progress)
+	}
+	if entries := s.listEntries(admin.Token.Id, "/book"); entries[0]["name"] != "A" || entries[0]["title"] != nil {
+		t.Errorf("dry run changed entry %v", entries[0])
+	}
+
+	// batches continue at the cursor; entries steps fail for are left as they are
+	code, progress = s.migrate(admin.Token.Id, `{"batchSize":2,"steps":`+steps+`}`)
+	if code != http.StatusOK || progress.Done || len(progress.Cursor) == 0 || progress.Read != 2 || progress.Changed != 1 || len(progress.Failed) != 1 {
+		t.Fatalf("first batch responded %d %+v", code, progress)
+	}
+	code, progress = s.migrate(admin.Token.Id, `{"batchSize":2,"cursor":"`+progress.Cursor+`","steps":`+steps+`}`)
+	if code != http.StatusOK || !progress.Done || progress.Read != 1 || progress.Changed != 1 {
+		t.Fatalf("second batch responded %d %+v", code, progress)
+	}
+
+	entries := s.listEntries(admin.Token.Id, "/book")
+	a, b, c := entries[0], entries[1], entries[2]
+	if a["title"] != "A" || a["name"] != nil || a["first"] != "Ann" || a["last"] != "Lee" || a["pages"] != float64(12) || a["status"] != "draft" {
+		t.Errorf("migrated entry %v", a)
+	}
+	if tags, _ := a["tag"].([]interface{}); len(tags) != 1 || tags[0] != "x" {
+		t.Errorf("migrated entry %v", a)
+	}
+	if b["name"] != "B" || b["pages"] != "many" || b["status"] != nil {
+		t.Errorf("failed entry changed to %v", b)
+	}
+	if c["title"] != "C" || c["first"] != nil || c["status"] != "draft" {
+		t.Errorf("migrated entry %v", c)
+	}
+
+	if code, progress = s.migrate(admin.Token.Id, `{"steps":`+steps+`}`); code != http.StatusOK || progress.Changed != 0 {
+		t.Errorf("migrating again responded %d %+v", code, progress)
+	}
+
+	code, progress = s.migrate(admin.Token.Id, `{"steps":[{"op":"merge","from":["first","last"],"to":["title"],"separator":" "}]}`)
+	if code != http.StatusOK || progress.Changed != 1 {
+		t.Errorf("merge responded %d %+v", code, progress)
+	}
+	if a := s.listEntries(admin.Token.Id, "/book")[0]; a["title"] != "Ann Lee" || a["first"] != nil || a["last"] != nil {
+		t.Errorf("merged entry %v", a)
+	}
+}
+
+func TestKindMigrationRefused(t *testing.T) {
+	s := newKindsTestServer(t, Options{})
+	admin := s.registerInGroups("admin@example.com", "admin")
+	if code, _ := s.do(http.MethodPost, "/kinds", `{"name":"book","fields":[{"name":"title"}]}`, admin.Token.Id); code != http.StatusCreated {
+		t.Fatalf("add kind responded %d", code)
+	}
+
+	for _, input := range []string{
+		`{"steps":[]}`,
+		`{"steps":[null]}`,
+		`{"steps":[{"op":"drop","field":"title"}]}`,
+		`{"steps":[{"op":"type","field":"title","type":"date"}]}`,
+		`{"steps":[{"op":"rename","from":["name"]}]}`,
+		`{"cursor":"!!","steps":[{"op":"default","field":"title","value":"a"}]}`,
+	} {
+		if code, _ := s.migrate(admin.Token.Id, input); code != http.StatusBadRequest {
+			t.Errorf("migration %s responded %d", input, code)
+		}
+	}
+	if code, _ := s.migrate(s.register("user@example.com").Token.Id, `{"steps":[{"op":"default","field":"title","value":"a"}]}`); code != http.StatusForbidden {
+		t.Errorf("migration without permission responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/kinds/nope/migrations", `{"steps":[{"op":"default","field":"title","value":"a"}]}`, admin.Token.Id); code != http.StatusNotFound {
+		t.Errorf("migration of unknown kind responded %d", code)
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// failure counter starts over when there was no failed attempt for this long
+	loginAttemptsReset = time.Hour * 24
+	maxLoginLockout    = time.Hour * 24
+)
+
+// Failed login attempts of an account ("user:email") or IP address ("ip:address")
+type loginAttempts struct {
+	Failures    int       `datastore:"failures,noindex"`
+	LastFailure time.Time `datastore:"lastFailure,noindex"`
+	LockedUntil time.Time `datastore:"lockedUntil,noindex"`
+}
+
+// Audit record of a failed login, stored as kind "LoginFailure"
+type LoginFailure struct {
+	Email     string    `datastore:"email" json:"email"`
+	IP        string    `datastore:"ip" json:"ip"`
+	Reason    string    `datastore:"reason,noindex" json:"reason"`
+	CreatedAt time.Time `datastore:"createdAt" json:"createdAt"`
+}
+
+func loginAttemptsKey(ctx context.Context, name string) *datastore.Key {
+	return datastore.NewKey(ctx, "LoginAttempts", name, 0, nil)
+}
+
+// Parses Options.TrustedProxies; single addresses are ranges of one address
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, errors.New("trusted proxy '" + p + "' is not an IP address or CIDR range")
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errors.New("trusted proxy '" + p + "' is not an IP address or CIDR range")
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (a *App) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client IP address; requests from trusted proxies are read from Options.ClientIPHeader, where the last
+// address that isn't a trusted proxy is the client as earlier ones can be set by the client itself
+func (a *App) remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !a.isTrustedProxy(ip) {
+		return ip
+	}
+
+	var addrs []string
+	for _, header := range r.Header[http.CanonicalHeaderKey(a.Options.ClientIPHeader)] {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); len(addr) > 0 {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if net.ParseIP(addrs[i]) == nil {
+			break
+		}
+		ip = addrs[i]
+		if !a.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Returns how long login for the account or IP address is still locked out
+func (a *App) loginLockout(ctx context.Context, email string, ip string) (time.Duration, error) {
+	var lockout time.Duration
+	for _, name := range []string{"user:" + email, "ip:" + ip} {
+		var la = new(loginAttempts)
+		err := a.Options.Store.Get(ctx, loginAttemptsKey(ctx, name), la)
+		if err != nil {
+			if err == store.ErrNoSuchEntity {
+				continue
+			}
+			return 0, err
+		}
+		if d := time.Until(la.LockedUntil); d > lockout {
+			lockout = d
+		}
+	}
+	return lockout, nil
+}
+
+// Responds with ErrLoginLocked and Retry-After header
+func printLoginLocked(ctx instance.Context, w http.ResponseWriter, lockout time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(lockout/time.Second)+1))
+	ctx.PrintError(w, instance.ErrLoginLocked)
+}
+
+// Records failed login and counts it against the account and IP address
+func (a *App) loginFailed(ctx context.Context, email string, ip string, reason string) {
+	var now = time.Now()
+
+	_, err := a.Options.Store.Put(ctx, datastore.NewIncompleteKey(ctx, "LoginFailure", nil), &LoginFailure{
+		Email:     email,
+		IP:        ip,
+		Reason:    reason,
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Printf("recording failed login of %s: %v", email, err)
+	}
+
+	if err := a.countLoginFailure(ctx, "user:"+email, a.Options.MaxLoginAttempts, now); err != nil {
+		log.Printf("counting failed login of %s: %v", email, err)
+	}
+	if err := a.countLoginFailure(ctx, "ip:"+ip, a.Options.MaxLoginAttemptsPerIP, now); err != nil {
+		log.Printf("counting failed login from %s: %v", ip, err)
+	}
+}
+
+// Increases failure counter; once it reaches max the lockout starts at Options.LoginLockout and doubles with each failure
+func (a *App) countLoginFailure(ctx context.Context, name string, max int, now time.Time) error {
+	if max < 0 {
+		return nil
+	}
+	return a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+		key := loginAttemptsKey(tc, name)
+		var la = new(loginAttempts)
+		err := a.Options.Store.Get(tc, key, la)
+		if err != nil && err != store.ErrNoSuchEntity {
+			return err
+		}
+
+		if now.Sub(la.LastFailure) > loginAttemptsReset {
+			la.Failures = 0
+		}
+		la.Failures++
+		la.LastFailure = now
+
+		if la.Failures >= max {
+			lockout := a.Options.LoginLockout
+			for i := max; i < la.Failures && lockout < maxLoginLockout; i++ {
+				lockout *= 2
+			}
+			if lockout > maxLoginLockout {
+				lockout = maxLoginLockout
+			}
+			la.LockedUntil = now.Add(lockout)
+		}
+
+		_, err = a.Options.Store.Put(tc, key, la)
+		return err
+	})
+}
+
+// Clears failed attempts of the account, e.g. after successful login or password reset
+func (a *App) resetLoginAttempts(ctx context.Context, email string) error {
+	err := a.Options.Store.Delete(ctx, loginAttemptsKey(ctx, "user:"+email))
+	if err == store.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t, Options{MaxLoginAttempts: 3, ResetPasswordURL: "https://example.com/reset"})
+	s.register("user@example.com")
+	s.register("other@example.com")
+
+	for i := 0; i < 3; i++ {
+		if code, _ := s.do(http.MethodPost, "/auth/login", `{"email":"user@example.com","password":"wrong11"}`, ""); code != http.StatusBadRequest {
+			t.Fatalf("login with wrong password responded %d", code)
+		}
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/login", `{"email":"user@example.com","password":"secret1"}`, ""); code != http.StatusTooManyRequests {
+		t.Errorf("login to locked account responded %d", code)
+	}
+	s.login("other@example.com")
+
+	// password reset proves email ownership and lifts the lockout
+	s.do(http.MethodPost, "/auth/reset-password/send", `{"email":"user@example.com"}`, "")
+	if code, _ := s.do(http.MethodPost, "/auth/reset-password", `{"token":"`+s.mailedToken("user@example.com")+`","password":"secret1"}`, ""); code != http.StatusNoContent {
+		t.Fatalf("reset responded %d", code)
+	}
+	s.login("user@example.com")
+}
+
+func TestLoginLockoutPerIP(t *testing.T) {
+	s := newTestServer(t, Options{MaxLoginAttemptsPerIP: 3})
+	s.register("user@example.com")
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if code, _ := s.do(http.MethodPost, "/auth/login", `{"email":"`+email+`","password":"secret1"}`, ""); code != http.StatusBadRequest {
+			t.Fatalf("login of unknown user responded %d", code)
+		}
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/login", `{"email":"user@example.com","password":"secret1"}`, ""); code != http.StatusTooManyRequests {
+		t.Errorf("login from locked IP address responded %d", code)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	s := newTestServer(t, Options{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+	for _, c := range []struct{ remote, header, want string }{
+		{"1.2.3.4:5", "9.9.9.9", "1.2.3.4"},
+		{"10.0.0.1:5", "6.6.6.6, 9.9.9.9, 192.168.1.1", "9.9.9.9"},
+		{"10.0.0.1:5", "", "10.0.0.1"},
+		{"10.0.0.1:5", "10.1.1.1", "10.1.1.1"},
+		{"192.168.1.1:5", "bogus, 8.8.8.8", "8.8.8.8"},
+	} {
+		r, _ := http.NewRequest(http.MethodPost, "/auth/login", nil)
+		r.RemoteAddr = c.remote
+		if len(c.header) > 0 {
+			r.Header.Set("X-Forwarded-For", c.header)
+		}
+		if ip := s.app.remoteIP(r); ip != c.want {
+			t.Errorf("request from %s forwarded for %q has IP address %s, expected %s", c.remote, c.header, ip, c.want)
+		}
+	}
+}
+
+func TestInvalidTrustedProxy(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewApp accepted invalid trusted proxy")
+		}
+	}()
+	newTestServer(t, Options{TrustedProxies: []string{"proxy"}})
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/oidc"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const oidcStateAudience = "oidc-state"
+
+// how long user has to complete login with the provider
+var OIDCStateLifetime = time.Minute * 10
+
+// Link of identity provider subject to a user, stored as kind "Identity" with key name "provider:subject"
+type identity struct {
+	User      string    `datastore:"user"`
+	CreatedAt time.Time `datastore:"createdAt,noindex"`
+}
+
+func identityKey(ctx context.Context, provider string, subject string) *datastore.Key {
+	return datastore.NewKey(ctx, "Identity", provider+":"+subject, 0, nil)
+}
+
+func (a *App) identityProvider(r *http.Request) (string, *oidc.Provider, error) {
+	name := mux.Vars(r)["provider"]
+	p, ok := a.Options.IdentityProviders[name]
+	if !ok {
+		return name, nil, datastore.ErrNoSuchEntity
+	}
+	return name, p, nil
+}
+
+// Redirects to identity provider login
+func (a *App) AuthOIDCHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		name, p, err := a.identityProvider(r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		nonce, err := randomToken(16)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// state is a signed token carrying the nonce so no server side state is needed
+		state, err := a.keys.SignToken(jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"aud": oidcStateAudience,
+			"pvd": name,
+			"nnc": nonce,
+			"exp": time.Now().Add(OIDCStateLifetime).Unix(),
+		}))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		authURL, err := p.AuthCodeURL(ctx, state, nonce)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// Completes identity provider login with code and state query parameters and responds like login does
+func (a *App) AuthOIDCCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		name, p, err := a.identityProvider(r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		q := r.URL.Query()
+		if e := q.Get("error"); len(e) > 0 {
+			ctx.PrintError(w, instance.NewError(instance.ErrIdentityLogin.Error()+": "+e, instance.ErrIdentityLogin.Code))
+			return
+		}
+
+		state, err := a.parseToken(q.Get("state"), oidcStateAudience)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if pvd, _ := state["pvd"].(string); pvd != name {
+			ctx.PrintError(w, instance.ErrInvalidToken)
+			return
+		}
+		nonce, _ := state["nnc"].(string)
+
+		claims, err := p.Exchange(ctx, q.Get("code"), nonce)
+		if err != nil {
+			log.Printf("identity provider %s login: %v", name, err)
+			ctx.PrintError(w, instance.ErrIdentityLogin)
+			return
+		}
+
+		u, err := a.identityUser(ctx, name, claims)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		if u.Disabled {
+			ctx.PrintError(w, instance.ErrUserDisabled)
+			return
+		}
+		if u.TOTPEnabled {
+			a.printTwoFactorChallenge(ctx, w, u)
+			return
+		}
+
+		// get user projects
+		u.Projects, _ = project.GetUserProjects(ctx, a.Options.Store, datastore.NewKey(ctx, "User", u.Email, 0, nil))
+
+		signedToken, refreshToken, err := a.newSession(ctx, u, false)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintAuth(w, u, signedToken, refreshToken)
+	}
+}
+
+// Returns user linked to the provider subject. On first login the subject is linked to the user with
+// the same email, which is created if it doesn't exist; provider has to confirm the email is verified.
+func (a *App) identityUser(ctx context.Context, provider string, claims *oidc.Claims) (*user.User, error) {
+	var u = new(user.User)
+	err := a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+		idKey := identityKey(tc, provider, claims.Subject)
+		var id = new(identity)
+		err := a.Options.Store.Get(tc, idKey, id)
+		if err == nil {
+			return a.Options.Store.Get(tc, datastore.NewKey(tc, "User", id.User, 0, nil), u)
+		}
+		if err != store.ErrNoSuchEntity {
+			return err
+		}
+
+		if len(claims.Email) == 0 || !claims.EmailVerified {
+			return instance.NewError(instance.ErrIdentityLogin.Error()+": provider didn't return a verified email", instance.ErrIdentityLogin.Code)
+		}
+		email := strings.ToLower(claims.Email)
+
+		userKey := datastore.NewKey(tc, "User", email, 0, nil)
+		err = a.Options.Store.Get(tc, userKey, u)
+		if err == store.ErrNoSuchEntity {
+			u = &user.User{
+				Email:         email,
+				FirstName:     claims.GivenName,
+				LastName:      claims.FamilyName,
+				Photo:         claims.Picture,
+				EmailVerified: true,
+			}
+		} else if err != nil {
+			return err
+		}
+		u.EmailVerified = true
+		if _, err = a.Options.Store.Put(tc, userKey, u); err != nil {
+			return err
+		}
+
+		_, err = a.Options.Store.Put(tc, idKey, &identity{User: email, CreatedAt: time.Now()})
+		return err
+	})
+	return u, err
+}
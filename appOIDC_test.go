@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/oidc"
+	"github.com/ales6164/go-cms/oidc/oidctest"
+)
+
+// Serves App with identity providers "corp" and "other", both logging in with the returned issuer
+func newOIDCTestServer(t *testing.T) (*testServer, *oidctest.Issuer) {
+	iss, err := oidctest.NewIssuer("cms", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(iss.Close)
+
+	// redirect URLs are known once the server is started
+	var providers = map[string]*oidc.Provider{}
+	s := newTestServer(t, Options{IdentityProviders: providers})
+	for _, name := range []string{"corp", "other"} {
+		providers[name] = iss.Provider(s.URL + "/auth/oidc/" + name + "/callback")
+	}
+	return s, iss
+}
+
+// Logs in with the issuer and returns callback path with query parameters
+func (s *testServer) oidcCallback(iss *oidctest.Issuer, provider string) string {
+	callback, err := iss.Login(s.URL + "/auth/oidc/" + provider)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return callback.Path + "?" + callback.RawQuery
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s, iss := newOIDCTestServer(t)
+	iss.Identity.GivenName = "Ann"
+
+	callback := s.oidcCallback(iss, "corp")
+	var auth = new(instance.AuthResult)
+	if code := s.doJSON(http.MethodGet, callback, "", "", auth); code != http.StatusOK {
+		t.Fatalf("callback responded %d", code)
+	}
+	if auth.User.Email != "user@example.com" || auth.User.FirstName != "Ann" || !auth.User.EmailVerified {
+		t.Errorf("callback created user %+v", auth.User)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusOK {
+		t.Errorf("me responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, callback, "", ""); code != http.StatusBadRequest {
+		t.Errorf("reused callback responded %d", code)
+	}
+
+	// the subject stays linked to the user even when the provider changes the email
+	iss.Identity.Email = "renamed@example.com"
+	auth = new(instance.AuthResult)
+	if code := s.doJSON(http.MethodGet, s.oidcCallback(iss, "corp"), "", "", auth); code != http.StatusOK || auth.User.Email != "user@example.com" {
+		t.Errorf("second login responded %d for %+v", code, auth.User)
+	}
+}
+
+// First login links the subject to the user with the same email
+func TestOIDCLinksUser(t *testing.T) {
+	s, iss := newOIDCTestServer(t)
+	s.register("user@example.com")
+
+	var auth = new(instance.AuthResult)
+	if code := s.doJSON(http.MethodGet, s.oidcCallback(iss, "corp"), "", "", auth); code != http.StatusOK || auth.User.Email != "user@example.com" {
+		t.Fatalf("callback responded %d for %+v", code, auth.User)
+	}
+	s.login("user@example.com")
+}
+
+func TestOIDCRefused(t *testing.T) {
+	s, iss := newOIDCTestServer(t)
+
+	if code, _ := s.do(http.MethodGet, "/auth/oidc/unknown", "", ""); code != http.StatusNotFound {
+		t.Errorf("unknown provider responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/oidc/corp/callback?error=access_denied", "", ""); code != http.StatusBadRequest {
+		t.Errorf("callback with error responded %d", code)
+	}
+
+	// state is bound to the provider login started with
+	callback, err := iss.Login(s.URL + "/auth/oidc/corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/oidc/other/callback?"+callback.RawQuery, "", ""); code != http.StatusBadRequest {
+		t.Errorf("callback of another provider responded %d", code)
+	}
+
+	iss.Identity.EmailVerified = false
+	if code, _ := s.do(http.MethodGet, s.oidcCallback(iss, "corp"), "", ""); code != http.StatusBadRequest {
+		t.Errorf("callback with unverified email responded %d", code)
+	}
+}
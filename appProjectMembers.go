@@ -0,0 +1,343 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/mail"
+	"github.com/ales6164/go-cms/project"
+	"github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// Checks if authenticated user is owner of the {project} route variable; returns project namespace
+func (a *App) authorizeProjectOwner(ctx instance.Context, r *http.Request) (string, error) {
+	if err := authenticatedUser(ctx); err != nil {
+		return "", err
+	}
+	namespace := mux.Vars(r)["project"]
+	m, err := project.GetMember(ctx, a.Options.Store, namespace, ctx.User)
+	if err != nil {
+		return namespace, projectError(err)
+	}
+	if m.Role != project.RoleOwner {
+		return namespace, instance.ErrForbidden
+	}
+	return namespace, nil
+}
+
+// Members get one of Options.ProjectRoles; owner role is given by transferring ownership
+func (a *App) validRole(role string) error {
+	if role == project.RoleOwner {
+		return instance.ErrProjectOwnerRole
+	}
+	for _, r := range a.Options.ProjectRoles {
+		if role == r {
+			return nil
+		}
+	}
+	return instance.ErrInvalidRole
+}
+
+// Tells invitee about the invitation; it is accepted on /auth/invitations/{project}
+func (a *App) SendInvitationEmail(ctx context.Context, p *project.Project, inv *project.Invitation) error {
+	var body = "You were invited to project " + p.Name + " as " + inv.Role + " by " + inv.InvitedBy + "."
+	if len(a.Options.InvitationURL) > 0 {
+		body += " Open the link below to accept or decline it:\n\n" + pageLink(a.Options.InvitationURL, "project", p.Namespace)
+	}
+	return a.Options.Mailer.Send(ctx, &mail.Message{
+		To:      inv.Email,
+		Subject: "Invitation to " + p.Name,
+		Body:    body,
+	})
+}
+
+// Lists members of the project; only its members can read them
+func (a *App) ProjectMembersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		namespace := mux.Vars(r)["project"]
+		if _, err := project.GetMember(ctx, a.Options.Store, namespace, ctx.User); err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		members, err := project.GetMembers(ctx, a.Options.Store, namespace)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, members)
+	}
+}
+
+type roleInput struct {
+	Role string `json:"role"`
+}
+
+// Changes member role for {"role": "..."}; owner only
+func (a *App) ProjectMemberRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		namespace, err := a.authorizeProjectOwner(ctx, r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input roleInput
+		err = json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if err := a.validRole(input.Role); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		m, err := project.SetRole(ctx, a.Options.Store, namespace, strings.ToLower(mux.Vars(r)["email"]), input.Role)
+		if err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		ctx.PrintResult(w, m)
+	}
+}
+
+// Removes member from the project; owner can remove anyone but themselves, other members can only leave
+func (a *App) RemoveProjectMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		email := strings.ToLower(mux.Vars(r)["email"])
+
+		var namespace string
+		var err error
+		if email == ctx.User {
+			err = authenticatedUser(ctx)
+			namespace = mux.Vars(r)["project"]
+		} else {
+			namespace, err = a.authorizeProjectOwner(ctx, r)
+		}
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = project.RemoveMember(ctx, a.Options.Store, namespace, email)
+		if err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+type emailRoleInput struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Transfers ownership to member for {"email": "...", "role": "..."}; the current owner gets role
+func (a *App) ProjectOwnerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		namespace, err := a.authorizeProjectOwner(ctx, r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input emailRoleInput
+		err = json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if err := a.validRole(input.Role); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = project.TransferOwnership(ctx, a.Options.Store, namespace, ctx.User, strings.ToLower(input.Email), input.Role)
+		if err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		members, err := project.GetMembers(ctx, a.Options.Store, namespace)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, members)
+	}
+}
+
+// Lists pending invitations of the project; owner only
+func (a *App) ProjectInvitationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		namespace, err := a.authorizeProjectOwner(ctx, r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		invitations, err := project.GetInvitations(ctx, a.Options.Store, namespace)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, invitations)
+	}
+}
+
+// Invites user for {"email": "...", "role": "..."} and emails them; owner only.
+// The user doesn't need to have an account yet.
+func (a *App) InviteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		namespace, err := a.authorizeProjectOwner(ctx, r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input emailRoleInput
+		err = json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		input.Email = strings.ToLower(input.Email)
+		if !govalidator.IsEmail(input.Email) {
+			ctx.PrintError(w, instance.ErrInvalidEmail)
+			return
+		}
+		if err := a.validRole(input.Role); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		p, err := project.Get(ctx, a.Options.Store, namespace)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		inv, err := project.Invite(ctx, a.Options.Store, namespace, input.Email, input.Role, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		if err := a.SendInvitationEmail(ctx, p, inv); err != nil {
+			log.Printf("sending invitation to %s: %v", inv.Email, err)
+		}
+
+		ctx.PrintStatus(w, http.StatusCreated, inv)
+	}
+}
+
+// Cancels invitation; owner only
+func (a *App) CancelInvitationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		namespace, err := a.authorizeProjectOwner(ctx, r)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = project.DeleteInvitation(ctx, a.Options.Store, namespace, strings.ToLower(mux.Vars(r)["email"]))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+// Lists pending invitations of the authenticated user
+func (a *App) AuthInvitationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		invitations, err := project.GetUserInvitations(ctx, a.Options.Store, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, invitations)
+	}
+}
+
+// Accepts invitation to the project; user has to have a verified email since invitations are sent by email
+func (a *App) AuthAcceptInvitationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		u, err := a.getUser(ctx, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if !u.EmailVerified {
+			ctx.PrintError(w, instance.ErrEmailNotVerified)
+			return
+		}
+
+		m, err := project.Accept(ctx, a.Options.Store, mux.Vars(r)["project"], ctx.User)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, m)
+	}
+}
+
+// Declines invitation to the project
+func (a *App) AuthDeclineInvitationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err := project.DeleteInvitation(ctx, a.Options.Store, mux.Vars(r)["project"], ctx.User)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
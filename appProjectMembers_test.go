@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/user"
+)
+
+// Serves App with kind "post" and project roles "editor" and "viewer"
+func newMembersTestServer(t *testing.T) *testServer {
+	s := newTestServer(t, Options{
+		Permissions: user.Permissions{
+			"admin":  {"*:*"},
+			"owner":  {"post:*"},
+			"editor": {"post:*"},
+			"viewer": {"post:read"},
+		},
+		ProjectRoles:   []string{"editor", "viewer"},
+		VerifyEmailURL: "https://example.com/verify",
+		InvitationURL:  "https://example.com/invitations",
+	})
+	if err := s.app.Import(kind.New("post", []*kind.Field{{Name: "title", Worker: &field.Text{}}})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Registers user and verifies their email, which accepting invitations requires
+func (s *testServer) registerVerified(email string) *instance.AuthResult {
+	auth := s.register(email)
+	if code, _ := s.do(http.MethodPost, "/auth/verify-email", `{"token":"`+s.mailedToken(email)+`"}`, ""); code != http.StatusOK {
+		s.t.Fatalf("verifying %s responded %d", email, code)
+	}
+	return auth
+}
+
+func (s *testServer) invite(token string, namespace string, email string, role string) int {
+	code, _ := s.do(http.MethodPost, "/projects/"+namespace+"/invitations", `{"email":"`+email+`","role":"`+role+`"}`, token)
+	return code
+}
+
+func TestProjectInvitation(t *testing.T) {
+	s := newMembersTestServer(t)
+	alice := s.registerVerified("alice@example.com")
+	bob := s.register("bob@example.com")
+	s.createProject(alice.Token.Id, "acme")
+
+	for _, role := range []string{"admin", project.RoleOwner, ""} {
+		if code := s.invite(alice.Token.Id, "acme", "bob@example.com", role); code != http.StatusBadRequest {
+			t.Errorf("inviting as %q responded %d", role, code)
+		}
+	}
+	if code := s.invite(alice.Token.Id, "acme", "bob", "viewer"); code != http.StatusBadRequest {
+		t.Errorf("inviting invalid email responded %d", code)
+	}
+	if code := s.invite(bob.Token.Id, "acme", "bob@example.com", "viewer"); code != http.StatusForbidden {
+		t.Errorf("inviting by non-member responded %d", code)
+	}
+
+	if code := s.invite(alice.Token.Id, "acme", "Bob@example.com", "viewer"); code != http.StatusCreated {
+		t.Fatalf("invite responded %d", code)
+	}
+	if msg := s.mailer.last("bob@example.com"); msg == nil || !strings.Contains(msg.Body, "https://example.com/invitations?project=acme") {
+		t.Errorf("invitation email %v", msg)
+	}
+	var invitations []*project.Invitation
+	if code := s.doJSON(http.MethodGet, "/auth/invitations", "", bob.Token.Id, &invitations); code != http.StatusOK || len(invitations) != 1 || invitations[0].Role != "viewer" {
+		t.Errorf("invitations responded %d %v", code, invitations)
+	}
+
+	if code, _ := s.do(http.MethodPost, "/auth/invitations/acme", "", bob.Token.Id); code != http.StatusBadRequest {
+		t.Errorf("accept with unverified email responded %d", code)
+	}
+	s.do(http.MethodPost, "/auth/verify-email/send", `{"email":"bob@example.com"}`, "")
+	if code, _ := s.do(http.MethodPost, "/auth/verify-email", `{"token":"`+s.mailedToken("bob@example.com")+`"}`, ""); code != http.StatusOK {
+		t.Fatalf("verify responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/invitations/acme", "", bob.Token.Id); code != http.StatusOK {
+		t.Fatalf("accept responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/invitations/acme", "", bob.Token.Id); code != http.StatusNotFound {
+		t.Errorf("accepting again responded %d", code)
+	}
+}
+
+// Owner changes member roles to Options.ProjectRoles; the change applies to tokens already issued
+func TestProjectMemberRoles(t *testing.T) {
+	s := newMembersTestServer(t)
+	alice := s.registerVerified("alice@example.com")
+	bob := s.registerVerified("bob@example.com")
+	s.createProject(alice.Token.Id, "acme")
+	s.invite(alice.Token.Id, "acme", "bob@example.com", "viewer")
+	if code, _ := s.do(http.MethodPost, "/auth/invitations/acme", "", bob.Token.Id); code != http.StatusOK {
+		t.Fatalf("accept responded %d", code)
+	}
+	acme := s.switchProject(bob.Token.Id, "acme")
+
+	if code, _ := s.do(http.MethodPost, "/post", `{"title":"b"}`, acme); code != http.StatusForbidden {
+		t.Errorf("add by viewer responded %d", code)
+	}
+	for _, role := range []string{"admin", project.RoleOwner} {
+		if code, _ := s.do(http.MethodPut, "/projects/acme/members/bob@example.com", `{"role":"`+role+`"}`, alice.Token.Id); code != http.StatusBadRequest {
+			t.Errorf("changing role to %s responded %d", role, code)
+		}
+	}
+	if code, _ := s.do(http.MethodPut, "/projects/acme/members/bob@example.com", `{"role":"editor"}`, acme); code != http.StatusForbidden {
+		t.Errorf("changing own role responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPut, "/projects/acme/members/bob@example.com", `{"role":"editor"}`, alice.Token.Id); code != http.StatusOK {
+		t.Fatalf("changing role responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/post", `{"title":"b"}`, acme); code != http.StatusCreated {
+		t.Errorf("add by editor responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodDelete, "/projects/acme/members/alice@example.com", "", acme); code != http.StatusForbidden {
+		t.Errorf("removing owner by member responded %d", code)
+	}
+	if code, _ := s.do(http.MethodDelete, "/projects/acme/members/bob@example.com", "", acme); code != http.StatusNoContent {
+		t.Fatalf("leaving responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/post", "", acme); code != http.StatusForbidden {
+		t.Errorf("list after leaving responded %d", code)
+	}
+}
+
+func TestProjectOwnership(t *testing.T) {
+	s := newMembersTestServer(t)
+	alice := s.registerVerified("alice@example.com")
+	bob := s.registerVerified("bob@example.com")
+	s.createProject(alice.Token.Id, "acme")
+	s.invite(alice.Token.Id, "acme", "bob@example.com", "viewer")
+	s.do(http.MethodPost, "/auth/invitations/acme", "", bob.Token.Id)
+
+	if code, _ := s.do(http.MethodPut, "/projects/acme/owner", `{"email":"bob@example.com","role":"admin"}`, alice.Token.Id); code != http.StatusBadRequest {
+		t.Errorf("transfer giving previous owner role admin responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPut, "/projects/acme/owner", `{"email":"bob@example.com","role":"editor"}`, alice.Token.Id); code != http.StatusOK {
+		t.Fatalf("transfer responded %d", code)
+	}
+	if code := s.invite(alice.Token.Id, "acme", "carol@example.com", "viewer"); code != http.StatusForbidden {
+		t.Errorf("inviting by previous owner responded %d", code)
+	}
+	if code := s.invite(bob.Token.Id, "acme", "carol@example.com", "viewer"); code != http.StatusCreated {
+		t.Errorf("inviting by new owner responded %d", code)
+	}
+	if code, _ := s.do(http.MethodDelete, "/projects/acme/invitations/carol@example.com", "", bob.Token.Id); code != http.StatusNoContent {
+		t.Errorf("cancelling invitation responded %d", code)
+	}
+}
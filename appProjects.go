@@ -0,0 +1,247 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// maps project package errors to API errors
+func projectError(err error) error {
+	switch err {
+	case project.ErrInvalidNamespace:
+		return instance.ErrInvalidProject
+	case project.ErrAlreadyExists:
+		return instance.ErrProjectAlreadyExists
+	case project.ErrNotMember:
+		return instance.ErrForbidden
+	case project.ErrAlreadyMember:
+		return instance.ErrProjectMember
+	case project.ErrOwnerRole:
+		return instance.ErrProjectOwnerRole
+	}
+	return err
+}
+
+// Moves context into namespace of the active project after checking the user is still its member
+// and adds their project role to user groups; API keys access the project they were created in while
+// their creator is its member, which useAPIKey checks
+func (a *App) projectContext(ctx instance.Context) (instance.Context, error) {
+	if len(ctx.Project) == 0 {
+		return ctx, nil
+	}
+	if len(ctx.APIKey) == 0 {
+		m, err := project.GetMember(ctx, a.Options.Store, ctx.Project, ctx.User)
+		if err != nil {
+			return ctx, projectError(err)
+		}
+		groups := append([]string{}, ctx.Groups...)
+		ctx.Groups = append(groups, a.twoFactorGroups([]string{m.Role}, ctx.TwoFactor)...)
+	}
+
+	nsCtx, err := project.Context(ctx.Context, ctx.Project)
+	if err != nil {
+		return ctx, err
+	}
+	ctx.Context = nsCtx
+	ctx.HasProjectAccess = true
+	return ctx, nil
+}
+
+// Rejects anonymous callers and API keys; projects are created and switched and API keys created by users only
+func authenticatedUser(ctx instance.Context) error {
+	if !ctx.IsAuthenticated {
+		return instance.ErrUnathorized
+	}
+	if len(ctx.APIKey) > 0 {
+		return instance.ErrForbidden
+	}
+	return nil
+}
+
+type projectInput struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name,omitempty"`
+}
+
+// Creates project for {"namespace": "...", "name": "..."} with the authenticated user as its owner
+func (a *App) AddProjectHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input projectInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		input.Namespace = strings.ToLower(input.Namespace)
+		input.Name = strings.TrimSpace(input.Name)
+		if len(input.Name) == 0 {
+			input.Name = input.Namespace
+		}
+
+		p, err := project.Create(ctx, a.Options.Store, input.Namespace, input.Name, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusCreated, p)
+	}
+}
+
+// Lists projects of the authenticated user
+func (a *App) ProjectsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		projects, err := project.GetUserProjects(ctx, a.Options.Store, ctx.UserKey)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, projects)
+	}
+}
+
+// Responds with project; only its members can read it
+func (a *App) ProjectHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		namespace := mux.Vars(r)["project"]
+		m, err := project.GetMember(ctx, a.Options.Store, namespace, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, projectError(err))
+			return
+		}
+
+		p, err := project.Get(ctx, a.Options.Store, namespace)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		p.Role = m.Role
+
+		ctx.PrintResult(w, p)
+	}
+}
+
+type switchProjectInput struct {
+	Project string `json:"project"`
+}
+
+// Switches active project for {"project": "..."} and responds with a new access token; kind routes
+// read and write entries of the active project. Empty project switches back to the default namespace.
+// The choice is stored on the session so tokens issued with its refresh token keep it.
+func (a *App) AuthProjectHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := authenticatedUser(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input switchProjectInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		if len(input.Project) > 0 {
+			if _, err := project.GetMember(ctx, a.Options.Store, input.Project, ctx.User); err != nil {
+				ctx.PrintError(w, projectError(err))
+				return
+			}
+		}
+
+		u, err := a.getUser(ctx, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if u.Disabled {
+			ctx.PrintError(w, instance.ErrUserDisabled)
+			return
+		}
+
+		if len(ctx.Session) > 0 {
+			err = a.setSessionProject(ctx, ctx.Session, input.Project)
+			if err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		token := instance.NewToken(u.Email, input.Project, a.tokenGroups(u, ctx.TwoFactor))
+		if len(ctx.Session) > 0 {
+			token.Claims.(jwt.MapClaims)["sid"] = ctx.Session
+		}
+		if ctx.TwoFactor {
+			token.Claims.(jwt.MapClaims)["mfa"] = true
+		}
+
+		signedToken, err := a.SignToken(token)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, signedToken)
+	}
+}
+
+func (a *App) setSessionProject(ctx context.Context, id string, namespace string) error {
+	return a.Options.Store.RunInTransaction(ctx, func(tc context.Context) error {
+		var s = new(session)
+		err := a.Options.Store.Get(tc, sessionKey(tc, id), s)
+		if err != nil {
+			if err == store.ErrNoSuchEntity {
+				return instance.ErrUnathorized
+			}
+			return err
+		}
+		s.Project = namespace
+		_, err = a.Options.Store.Put(tc, sessionKey(tc, id), s)
+		return err
+	})
+}
+
+// Drops active project from token when the user is no longer its member
+func (a *App) tokenProject(ctx context.Context, u *user.User, namespace string) (string, error) {
+	if len(namespace) == 0 {
+		return "", nil
+	}
+	_, err := project.GetMember(ctx, a.Options.Store, namespace, u.Email)
+	if err == project.ErrNotMember {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return namespace, nil
+}
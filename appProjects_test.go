@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/user"
+	"golang.org/x/net/context"
+)
+
+// Serves App with kind "post"; project owners manage posts, members read them and group "editor"
+// manages posts outside of projects
+func newProjectTestServer(t *testing.T) *testServer {
+	s := newTestServer(t, Options{Permissions: user.Permissions{
+		"editor": {"post:*"},
+		"owner":  {"post:*"},
+		"member": {"post:read"},
+	}})
+	if err := s.app.Import(kind.New("post", []*kind.Field{{Name: "title", Worker: &field.Text{}}})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Adds entry and returns its id
+func (s *testServer) addPost(token string, title string) string {
+	var added map[string]interface{}
+	if code := s.doJSON(http.MethodPost, "/post", `{"title":"`+title+`"}`, token, &added); code != http.StatusCreated {
+		s.t.Fatalf("add responded %d", code)
+	}
+	id, _ := added["id"].(string)
+	return id
+}
+
+func (s *testServer) countPosts(token string) int {
+	var list map[string]interface{}
+	if code := s.doJSON(http.MethodGet, "/post", "", token, &list); code != http.StatusOK {
+		s.t.Fatalf("list responded %d", code)
+	}
+	entries, _ := list["entries"].([]interface{})
+	return len(entries)
+}
+
+// Entries of one project can't be reached from another project or the default namespace
+func TestProjectIsolation(t *testing.T) {
+	s := newProjectTestServer(t)
+	alice := s.registerInGroups("alice@example.com", "editor")
+	bob := s.registerInGroups("bob@example.com", "editor")
+	s.createProject(alice.Token.Id, "acme")
+	s.createProject(bob.Token.Id, "other")
+	acme := s.switchProject(alice.Token.Id, "acme")
+	other := s.switchProject(bob.Token.Id, "other")
+
+	id := s.addPost(acme, "acme")
+	s.addPost(alice.Token.Id, "default")
+
+	if code, _ := s.do(http.MethodGet, "/post/"+id, "", acme); code != http.StatusOK {
+		t.Errorf("get in project responded %d", code)
+	}
+	for name, token := range map[string]string{"default namespace": alice.Token.Id, "another project": other} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			if code, _ := s.do(method, "/post/"+id, `{"title":"b"}`, token); code != http.StatusNotFound {
+				t.Errorf("%s of project entry from %s responded %d", method, name, code)
+			}
+		}
+	}
+	if n := s.countPosts(acme); n != 1 {
+		t.Errorf("project lists %d entries", n)
+	}
+	if n := s.countPosts(other); n != 0 {
+		t.Errorf("another project lists %d entries", n)
+	}
+
+	if code, _ := s.do(http.MethodPost, "/auth/project", `{"project":"acme"}`, bob.Token.Id); code != http.StatusForbidden {
+		t.Errorf("switching to project of others responded %d", code)
+	}
+}
+
+// Project role scopes apply only within the project and only while the user is a member
+func TestProjectMemberRole(t *testing.T) {
+	s := newProjectTestServer(t)
+	alice := s.register("alice@example.com")
+	bob := s.register("bob@example.com")
+	s.createProject(alice.Token.Id, "acme")
+	s.addPost(s.switchProject(alice.Token.Id, "acme"), "acme")
+
+	var ctx = context.Background()
+	if _, err := project.Invite(ctx, s.app.Options.Store, "acme", "bob@example.com", "member", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.Accept(ctx, s.app.Options.Store, "acme", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	acme := s.switchProject(bob.Token.Id, "acme")
+	if n := s.countPosts(acme); n != 1 {
+		t.Errorf("member lists %d entries", n)
+	}
+	if code, _ := s.do(http.MethodPost, "/post", `{"title":"b"}`, acme); code != http.StatusForbidden {
+		t.Errorf("add by member responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/post", "", bob.Token.Id); code != http.StatusForbidden {
+		t.Errorf("list outside of project responded %d", code)
+	}
+
+	if err := project.RemoveMember(ctx, s.app.Options.Store, "acme", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := s.do(http.MethodGet, "/post", "", acme); code != http.StatusForbidden {
+		t.Errorf("list by removed member responded %d", code)
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+var errSessionRevoked = errors.New("session revoked")
+
+// Server side session a refresh token belongs to; its id is a hash of the refresh token
+// and is carried in access tokens as "sid" claim
+type session struct {
+	User      string    `datastore:"user"`
+	CreatedAt time.Time `datastore:"createdAt,noindex"`
+	ExpiresAt time.Time `datastore:"expiresAt,noindex"`
+	TwoFactor bool      `datastore:"twoFactor,noindex"`
+	Project   string    `datastore:"project,noindex"` // active project
+}
+
+func sessionId(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, "Session", id, 0, nil)
+}
+
+// Creates a session and signs access token for it; returns access and refresh token.
+// twoFactor tells if user logged in with two-factor authentication.
+func (a *App) newSession(ctx context.Context, u *user.User, twoFactor bool) (*instance.Token, *instance.Token, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	id := sessionId(refreshToken)
+
+	var now = time.Now()
+	var s = &session{
+		User:      u.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.Options.RefreshTokenLifetime),
+		TwoFactor: twoFactor,
+	}
+	if _, err := a.Options.Store.Put(ctx, sessionKey(ctx, id), s); err != nil {
+		return nil, nil, err
+	}
+
+	token := instance.NewToken(u.Email, "", a.tokenGroups(u, twoFactor))
+	token.Claims.(jwt.MapClaims)["sid"] = id
+	if twoFactor {
+		token.Claims.(jwt.MapClaims)["mfa"] = true
+	}
+
+	signedToken, err := a.SignToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return signedToken, &instance.Token{Id: refreshToken, ExpiresAt: s.ExpiresAt.Unix()}, nil
+}
+
+// Rejects tokens whose session or API key was revoked or whose session expired, and access tokens without
+// session as those can't be revoked; used by auth middleware
+func (a *App) validateToken(r *http.Request, token *jwt.Token) error {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	if id, ok := claims["akid"].(string); ok && len(id) > 0 {
+		return a.useAPIKey(instance.NewContext(r), id)
+	}
+	id, ok := claims["sid"].(string)
+	if !ok || len(id) == 0 {
+		if claims.VerifyAudience(instance.TokenAudience, true) {
+			return errSessionRevoked
+		}
+		return nil
+	}
+
+	ctx := instance.NewContext(r)
+	_, err := a.getSession(ctx, id)
+	return err
+}
+
+// Returns session; revoked and expired sessions return errSessionRevoked, expired ones are deleted
+func (a *App) getSession(ctx context.Context, id string) (*session, error) {
+	var s = new(session)
+	err := a.Options.Store.Get(ctx, sessionKey(ctx, id), s)
+	if err == store.ErrNoSuchEntity {
+		return nil, errSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.ExpiresAt) {
+		a.RevokeSession(ctx, id)
+		return nil, errSessionRevoked
+	}
+	return s, nil
+}
+
+// Revokes session; access tokens issued for it stop working
+func (a *App) RevokeSession(ctx context.Context, id string) error {
+	return a.Options.Store.Delete(ctx, sessionKey(ctx, id))
+}
+
+// Revokes all sessions of the user
+func (a *App) RevokeSessions(ctx context.Context, email string) error {
+	return a.revokeSessions(ctx, email, "")
+}
+
+// Revokes sessions of the user except the one with id except
+func (a *App) revokeSessions(ctx context.Context, email string, except string) error {
+	entities, _, err := a.Options.Store.Run(ctx, store.NewQuery("Session").Filter("user", "=", email))
+	if err != nil {
+		return err
+	}
+	for _, e := range entities {
+		if e.Key.StringID() == except {
+			continue
+		}
+		if err := a.Options.Store.Delete(ctx, e.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revokes current session or with ?all=true all sessions of the user
+func (a *App) AuthLogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		var err error
+		if r.URL.Query().Get("all") == "true" {
+			err = a.RevokeSessions(ctx, ctx.User)
+		} else if len(ctx.Session) > 0 {
+			err = a.RevokeSession(ctx, ctx.Session)
+		}
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+// Revokes all sessions of a user; requires "user:update" permission
+func (a *App) AuthUserSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeUserManagement(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err := a.RevokeSessions(ctx, mux.Vars(r)["email"])
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
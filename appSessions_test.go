@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/user"
+)
+
+func TestSessions(t *testing.T) {
+	s := newTestServer(t, Options{Permissions: user.Permissions{"admin": {"user:*"}}})
+	auth := s.register("user@example.com")
+
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusOK {
+		t.Fatalf("me responded %d", code)
+	}
+
+	var refreshed = new(instance.Token)
+	code := s.doJSON(http.MethodPost, "/auth/refresh", `{"refreshToken":"`+auth.RefreshToken.Id+`"}`, "", refreshed)
+	if code != http.StatusOK || len(refreshed.Id) == 0 {
+		t.Fatalf("refresh responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodPost, "/auth/logout", "", auth.Token.Id); code != http.StatusNoContent {
+		t.Fatalf("logout responded %d", code)
+	}
+	for _, token := range []string{auth.Token.Id, refreshed.Id} {
+		if code, _ := s.do(http.MethodGet, "/auth/me", "", token); code != http.StatusUnauthorized {
+			t.Errorf("token of revoked session responded %d", code)
+		}
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/refresh", `{"refreshToken":"`+auth.RefreshToken.Id+`"}`, ""); code != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked session responded %d", code)
+	}
+}
+
+// Access tokens without a session can't be revoked, so they're refused
+func TestTokenWithoutSession(t *testing.T) {
+	s := newTestServer(t, Options{})
+	s.register("user@example.com")
+
+	token, err := s.app.SignToken(instance.NewToken("user@example.com", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", token.Id); code != http.StatusUnauthorized {
+		t.Errorf("token without session responded %d", code)
+	}
+}
+
+// Admins revoke all sessions of a user
+func TestRevokeUserSessions(t *testing.T) {
+	s := newTestServer(t, Options{Permissions: user.Permissions{"admin": {"user:*"}}})
+	first := s.register("user@example.com")
+	second := s.login("user@example.com")
+	admin := s.registerInGroups("admin@example.com", "admin")
+
+	if code, _ := s.do(http.MethodDelete, "/auth/users/user@example.com/sessions", "", first.Token.Id); code != http.StatusForbidden {
+		t.Errorf("revoking sessions without permission responded %d", code)
+	}
+	if code, _ := s.do(http.MethodDelete, "/auth/users/user@example.com/sessions", "", admin.Token.Id); code != http.StatusNoContent {
+		t.Fatalf("revoking sessions responded %d", code)
+	}
+	for _, auth := range []*instance.AuthResult{first, second} {
+		if code, _ := s.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusUnauthorized {
+			t.Errorf("token of revoked session responded %d", code)
+		}
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", admin.Token.Id); code != http.StatusOK {
+		t.Errorf("token of other user responded %d", code)
+	}
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/totp"
+	"github.com/ales6164/go-cms/user"
+	"github.com/dgrijalva/jwt-go"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	twoFactorAudience = "two-factor"
+	recoveryCodeCount = 10
+	totpSkew          = 1
+)
+
+// how long user has to enter the code after password login
+var TwoFactorTokenLifetime = time.Minute * 5
+
+// Response of login when user has two-factor authentication enabled;
+// login is completed on /auth/login/2fa with the token and a code
+type TwoFactorChallenge struct {
+	TwoFactorToken *instance.Token `json:"twoFactorToken"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"` // otpauth:// URL for QR code
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates single use recovery codes; returns codes and hashes stored on user
+func newRecoveryCodes() ([]string, []string, error) {
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	return codes, hashes, nil
+}
+
+// Checks TOTP or recovery code; a used code can't be used again. Changed user has to be stored.
+func verifyTwoFactor(u *user.User, code string) bool {
+	if counter, ok := totp.Validate(u.TOTPSecret, code, time.Now(), totpSkew); ok && counter > u.TOTPCounter {
+		u.TOTPCounter = counter
+		return true
+	}
+	hash := recoveryCodeHash(code)
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Responds with token for the second login step
+func (a *App) printTwoFactorChallenge(ctx instance.Context, w http.ResponseWriter, u *user.User) {
+	var exp = time.Now().Add(TwoFactorTokenLifetime).Unix()
+	signedToken, err := a.keys.SignToken(jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"aud": twoFactorAudience,
+		"sub": u.Email,
+		"exp": exp,
+	}))
+	if err != nil {
+		ctx.PrintError(w, err)
+		return
+	}
+
+	ctx.PrintResult(w, TwoFactorChallenge{TwoFactorToken: &instance.Token{Id: signedToken, ExpiresAt: exp}})
+}
+
+type twoFactorLoginInput struct {
+	TwoFactorToken string `json:"twoFactorToken"`
+	Code           string `json:"code"`
+}
+
+// Completes login for {"twoFactorToken": "...", "code": "..."}; code is a TOTP or recovery code.
+// Wrong codes count as failed logins.
+func (a *App) AuthTwoFactorLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := instance.NewContext(r)
+
+		var input twoFactorLoginInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		claims, err := a.parseEmailToken(input.TwoFactorToken, twoFactorAudience)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		email := claims["sub"].(string)
+
+		ip := a.remoteIP(r)
+		lockout, err := a.loginLockout(ctx, email, ip)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if lockout > 0 {
+			printLoginLocked(ctx, w, lockout)
+			return
+		}
+
+		u, err := a.updateUser(ctx, email, func(u *user.User) error {
+			if u.Disabled {
+				return instance.ErrUserDisabled
+			}
+			if !u.TOTPEnabled {
+				return instance.ErrTwoFactorNotEnabled
+			}
+			if !verifyTwoFactor(u, input.Code) {
+				return instance.ErrTwoFactorCode
+			}
+			return nil
+		})
+		if err != nil {
+			if err == instance.ErrTwoFactorCode {
+				a.loginFailed(ctx, email, ip, "two-factor code incorrect")
+			}
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.resetLoginAttempts(ctx, email)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// get user projects
+		u.Projects, _ = project.GetUserProjects(ctx, a.Options.Store, datastore.NewKey(ctx, "User", u.Email, 0, nil))
+
+		signedToken, refreshToken, err := a.newSession(ctx, u, true)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintAuth(w, u, signedToken, refreshToken)
+	}
+}
+
+// Creates TOTP secret for the authenticated user; two-factor authentication is enabled once a code is confirmed
+func (a *App) AuthTwoFactorEnrollHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		_, err = a.updateUser(ctx, ctx.User, func(u *user.User) error {
+			if u.TOTPEnabled {
+				return instance.ErrTwoFactorEnabled
+			}
+			u.TOTPSecret = secret
+			return nil
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, TwoFactorEnrollment{
+			Secret: secret,
+			URL:    totp.URL(a.Options.TwoFactorIssuer, ctx.User, secret),
+		})
+	}
+}
+
+type codeInput struct {
+	Code string `json:"code"`
+}
+
+// Enables two-factor authentication for {"code": "..."} from the enrolled authenticator;
+// responds with recovery codes which are shown only this time
+func (a *App) AuthTwoFactorConfirmHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		var input codeInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		_, err = a.updateUser(ctx, ctx.User, func(u *user.User) error {
+			if u.TOTPEnabled {
+				return instance.ErrTwoFactorEnabled
+			}
+			if len(u.TOTPSecret) == 0 {
+				return instance.ErrTwoFactorNotEnabled
+			}
+			counter, ok := totp.Validate(u.TOTPSecret, input.Code, time.Now(), totpSkew)
+			if !ok {
+				return instance.ErrTwoFactorCode
+			}
+			u.TOTPEnabled = true
+			u.TOTPCounter = counter
+			u.RecoveryCodes = hashes
+			return nil
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, RecoveryCodes{RecoveryCodes: codes})
+	}
+}
+
+// Disables two-factor authentication for {"code": "..."}; code is a TOTP or recovery code
+func (a *App) AuthTwoFactorDisableHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		var input codeInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		_, err = a.updateUser(ctx, ctx.User, func(u *user.User) error {
+			if !u.TOTPEnabled {
+				return instance.ErrTwoFactorNotEnabled
+			}
+			if !verifyTwoFactor(u, input.Code) {
+				return instance.ErrTwoFactorCode
+			}
+			u.TOTPEnabled = false
+			u.TOTPSecret = ""
+			u.TOTPCounter = 0
+			u.RecoveryCodes = nil
+			return nil
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/totp"
+	"github.com/ales6164/go-cms/user"
+)
+
+func totpCode(t *testing.T, secret string, counter int64) string {
+	code, err := totp.Code(secret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// Enrolls authenticated user and confirms with the current code; returns the secret, confirmed code and recovery codes
+func (s *testServer) enrollTwoFactor(token string) (string, string, []string) {
+	var enrollment = new(TwoFactorEnrollment)
+	if code := s.doJSON(http.MethodPost, "/auth/2fa/enroll", "", token, enrollment); code != http.StatusOK {
+		s.t.Fatalf("enroll responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/2fa/confirm", `{"code":"000000x"}`, token); code != http.StatusBadRequest {
+		s.t.Errorf("confirm with wrong code responded %d", code)
+	}
+
+	confirmed := totpCode(s.t, enrollment.Secret, totp.Counter(time.Now()))
+	var recovery = new(RecoveryCodes)
+	if code := s.doJSON(http.MethodPost, "/auth/2fa/confirm", `{"code":"`+confirmed+`"}`, token, recovery); code != http.StatusOK {
+		s.t.Fatalf("confirm responded %d", code)
+	}
+	return enrollment.Secret, confirmed, recovery.RecoveryCodes
+}
+
+// Logs in with password and returns the second step token
+func (s *testServer) twoFactorChallenge(email string) string {
+	var challenge = new(TwoFactorChallenge)
+	code := s.doJSON(http.MethodPost, "/auth/login", `{"email":"`+email+`","password":"secret1"}`, "", challenge)
+	if code != http.StatusOK || challenge.TwoFactorToken == nil {
+		s.t.Fatalf("login responded %d without two-factor challenge", code)
+	}
+	return challenge.TwoFactorToken.Id
+}
+
+func (s *testServer) twoFactorLogin(challenge string, code string) (int, *instance.AuthResult) {
+	var auth = new(instance.AuthResult)
+	return s.doJSON(http.MethodPost, "/auth/login/2fa", `{"twoFactorToken":"`+challenge+`","code":"`+code+`"}`, "", auth), auth
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := newTestServer(t, Options{})
+	secret, confirmed, recovery := s.enrollTwoFactor(s.register("user@example.com").Token.Id)
+	if len(recovery) != recoveryCodeCount {
+		t.Fatalf("confirm responded %d recovery codes", len(recovery))
+	}
+
+	challenge := s.twoFactorChallenge("user@example.com")
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", challenge); code != http.StatusUnauthorized {
+		t.Errorf("two-factor token as access token responded %d", code)
+	}
+	if code, _ := s.twoFactorLogin(challenge, confirmed); code != http.StatusBadRequest {
+		t.Errorf("login with code used to confirm responded %d", code)
+	}
+
+	next := totpCode(t, secret, totp.Counter(time.Now())+1)
+	code, auth := s.twoFactorLogin(challenge, next)
+	if code != http.StatusOK {
+		t.Fatalf("login with next code responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/auth/me", "", auth.Token.Id); code != http.StatusOK {
+		t.Errorf("me responded %d", code)
+	}
+	if code, _ := s.twoFactorLogin(s.twoFactorChallenge("user@example.com"), next); code != http.StatusBadRequest {
+		t.Errorf("second use of code responded %d", code)
+	}
+}
+
+func TestTwoFactorRecoveryCode(t *testing.T) {
+	s := newTestServer(t, Options{})
+	auth := s.register("user@example.com")
+	_, _, recovery := s.enrollTwoFactor(auth.Token.Id)
+
+	if code, _ := s.twoFactorLogin(s.twoFactorChallenge("user@example.com"), recovery[0]); code != http.StatusOK {
+		t.Fatalf("login with recovery code responded %d", code)
+	}
+	if code, _ := s.twoFactorLogin(s.twoFactorChallenge("user@example.com"), recovery[0]); code != http.StatusBadRequest {
+		t.Errorf("second use of recovery code responded %d", code)
+	}
+
+	if code, _ := s.do(http.MethodPost, "/auth/2fa/disable", `{"code":"`+recovery[0]+`"}`, auth.Token.Id); code != http.StatusBadRequest {
+		t.Errorf("disable with used recovery code responded %d", code)
+	}
+	if code, _ := s.do(http.MethodPost, "/auth/2fa/disable", `{"code":"`+recovery[1]+`"}`, auth.Token.Id); code != http.StatusNoContent {
+		t.Fatalf("disable responded %d", code)
+	}
+	s.login("user@example.com")
+}
+
+// Wrong codes count as failed logins
+func TestTwoFactorLockout(t *testing.T) {
+	s := newTestServer(t, Options{MaxLoginAttempts: 2})
+	s.enrollTwoFactor(s.register("user@example.com").Token.Id)
+
+	challenge := s.twoFactorChallenge("user@example.com")
+	for i := 0; i < 2; i++ {
+		if code, _ := s.twoFactorLogin(challenge, "000000x"); code != http.StatusBadRequest {
+			t.Fatalf("login with wrong code responded %d", code)
+		}
+	}
+	if code, _ := s.twoFactorLogin(challenge, "000000x"); code != http.StatusTooManyRequests {
+		t.Errorf("login to locked account responded %d", code)
+	}
+}
+
+// Scopes of RequireTwoFactor groups only apply to sessions started with two-factor authentication
+func TestRequireTwoFactor(t *testing.T) {
+	s := newTestServer(t, Options{Permissions: user.Permissions{"admin": {"post:*"}}, RequireTwoFactor: []string{"admin"}})
+	if err := s.app.Import(kind.New("post", []*kind.Field{{Name: "title", Worker: &field.Text{}}})); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := s.registerInGroups("admin@example.com", "admin")
+	if code, _ := s.do(http.MethodGet, "/post", "", auth.Token.Id); code != http.StatusForbidden {
+		t.Errorf("list without two-factor responded %d", code)
+	}
+
+	secret, _, _ := s.enrollTwoFactor(auth.Token.Id)
+	code, auth := s.twoFactorLogin(s.twoFactorChallenge("admin@example.com"), totpCode(t, secret, totp.Counter(time.Now())+1))
+	if code != http.StatusOK {
+		t.Fatalf("login responded %d", code)
+	}
+	if code, _ := s.do(http.MethodGet, "/post", "", auth.Token.Id); code != http.StatusOK {
+		t.Errorf("list with two-factor responded %d", code)
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type UserList struct {
+	Users  []*user.User `json:"users"`
+	Cursor string       `json:"cursor,omitempty"`
+}
+
+func (a *App) getUser(ctx context.Context, email string) (*user.User, error) {
+	var u = new(user.User)
+	err := a.Options.Store.Get(ctx, datastore.NewKey(ctx, "User", strings.ToLower(email), 0, nil), u)
+	if err == store.ErrNoSuchEntity {
+		return nil, instance.ErrUserDoesNotExist
+	}
+	return u, err
+}
+
+// Responds with the authenticated user
+func (a *App) AuthMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		u, err := a.getUser(ctx, ctx.User)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// get user projects
+		u.Projects, _ = project.GetUserProjects(ctx, a.Options.Store, ctx.UserKey)
+
+		ctx.PrintResult(w, u)
+	}
+}
+
+type profileInput struct {
+	FirstName *string `json:"firstName,omitempty"`
+	LastName  *string `json:"lastName,omitempty"`
+	Photo     *string `json:"photo,omitempty"`
+}
+
+// Updates profile of the authenticated user; fields missing from input are kept
+func (a *App) AuthUpdateMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		var input profileInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// verify input
+		if input.Photo != nil && len(*input.Photo) > 0 && !govalidator.IsURL(*input.Photo) {
+			ctx.PrintError(w, instance.ErrPhotoInvalidFormat)
+			return
+		}
+
+		u, err := a.updateUser(ctx, ctx.User, func(u *user.User) error {
+			if input.FirstName != nil {
+				u.FirstName = *input.FirstName
+			}
+			if input.LastName != nil {
+				u.LastName = *input.LastName
+			}
+			if input.Photo != nil {
+				u.Photo = *input.Photo
+			}
+			return nil
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, u)
+	}
+}
+
+type passwordInput struct {
+	Password    string `json:"password,omitempty"`
+	NewPassword string `json:"newPassword"`
+}
+
+// Changes password of the authenticated user for {"password": "...", "newPassword": "..."} and
+// revokes their other sessions. Users without a password, e.g. ones created by identity
+// provider login, only give the new one.
+func (a *App) AuthPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if !ctx.IsAuthenticated {
+			ctx.PrintError(w, instance.ErrUnathorized)
+			return
+		}
+
+		var input passwordInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		// verify input
+		if len(input.NewPassword) < 6 || len(input.NewPassword) > 128 {
+			ctx.PrintError(w, instance.ErrPasswordLength)
+			return
+		}
+
+		// create password hash
+		hash, err := crypt([]byte(input.NewPassword))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		_, err = a.updateUser(ctx, ctx.User, func(u *user.User) error {
+			if len(u.Hash) > 0 && decrypt(u.Hash, []byte(input.Password)) != nil {
+				return instance.ErrUserPasswordIncorrect
+			}
+			u.Hash = hash
+			return nil
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		err = a.revokeSessions(ctx, ctx.User, ctx.Session)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintStatus(w, http.StatusNoContent, nil)
+	}
+}
+
+// Lists users ordered by email; supports limit and cursor query parameters. Requires "user:read" permission.
+func (a *App) AuthUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, userPermissionName, user.Read); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		limit, err := listLimit(r.URL.Query())
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		q := store.NewQuery("User").Order("email", false)
+		q.Limit = limit
+		q.Cursor = r.URL.Query().Get("cursor")
+
+		entities, cursor, err := a.Options.Store.Run(ctx, q)
+		if err != nil {
+			if err == store.ErrInvalidCursor {
+				err = queryError("cursor is not valid")
+			}
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var result = UserList{Users: []*user.User{}}
+		for _, e := range entities {
+			var u = new(user.User)
+			if err := datastore.LoadStruct(u, e.Properties); err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+			result.Users = append(result.Users, u)
+		}
+		if len(entities) == limit {
+			result.Cursor = cursor
+		}
+
+		ctx.PrintResult(w, result)
+	}
+}
+
+// Responds with user; requires "user:read" permission
+func (a *App) AuthUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeAction(ctx, userPermissionName, user.Read); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		u, err := a.getUser(ctx, mux.Vars(r)["email"])
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		ctx.PrintResult(w, u)
+	}
+}
+
+type userDisabledInput struct {
+	Disabled bool `json:"disabled"`
+}
+
+// Disables or enables user for {"disabled": true|false}; disabled users can't log in and their sessions
+// are revoked. Requires "user:update" permission.
+func (a *App) AuthUserDisabledHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		if err := a.authorizeUserManagement(ctx); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		var input userDisabledInput
+		err := json.Unmarshal(ctx.Body(), &input)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		u, err := a.updateUser(ctx, mux.Vars(r)["email"], func(u *user.User) error {
+			u.Disabled = input.Disabled
+			return nil
+		})
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+
+		if u.Disabled {
+			err = a.RevokeSessions(ctx, u.Email)
+			if err != nil {
+				ctx.PrintError(w, err)
+				return
+			}
+		}
+
+		ctx.PrintResult(w, u)
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/mail"
+	"github.com/ales6164/go-cms/signing"
+	"github.com/ales6164/go-cms/store"
+	"github.com/ales6164/go-cms/user"
+	"golang.org/x/net/context"
+)
+
+// datastore.NewKey reads the app id from GAE_APPLICATION outside of App Engine
+func TestMain(m *testing.M) {
+	if len(os.Getenv("GAE_APPLICATION")) == 0 {
+		os.Setenv("GAE_APPLICATION", "dev~local")
+	}
+	os.Exit(m.Run())
+}
+
+// Mailer keeping sent messages
+type testMailer struct {
+	mu       sync.Mutex
+	messages []*mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg *mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Last message sent to the address
+func (m *testMailer) last(to string) *mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i]
+		}
+	}
+	return nil
+}
+
+// App served over HTTP with store.Memory
+type testServer struct {
+	*httptest.Server
+	app    *App
+	mailer *testMailer
+	t      *testing.T
+}
+
+// Serves App created with options; Store defaults to store.Memory, SigningKey to an HMAC key
+// and Mailer to a testMailer
+func newTestServer(t *testing.T, opts Options) *testServer {
+	if opts.Store == nil {
+		opts.Store = store.NewMemory()
+	}
+	if opts.SigningKey == nil {
+		opts.SigningKey = signing.NewHMAC("test", []byte("secret"))
+	}
+	var mailer = new(testMailer)
+	if opts.Mailer == nil {
+		opts.Mailer = mailer
+	}
+	a := NewApp(opts)
+	srv := httptest.NewServer(&Server{a.router("/")})
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, app: a, mailer: mailer, t: t}
+}
+
+// Sends request with bearer token unless it's empty; returns response status and body
+func (s *testServer) do(method, path, body, token string) (int, []byte) {
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return res.StatusCode, data
+}
+
+// Sends request and decodes JSON response into out
+func (s *testServer) doJSON(method, path, body, token string, out interface{}) int {
+	code, data := s.do(method, path, body, token)
+	if code < 300 && out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			s.t.Fatalf("%s %s responded %s: %v", method, path, data, err)
+		}
+	}
+	return code
+}
+
+// Registers user with password "secret1"
+func (s *testServer) register(email string) *instance.AuthResult {
+	var res = new(instance.AuthResult)
+	if code := s.doJSON(http.MethodPost, "/auth/register", `{"email":"`+email+`","password":"secret1"}`, "", res); code != http.StatusOK {
+		s.t.Fatalf("register %s responded %d", email, code)
+	}
+	return res
+}
+
+// Logs user registered by register in
+func (s *testServer) login(email string) *instance.AuthResult {
+	var res = new(instance.AuthResult)
+	if code := s.doJSON(http.MethodPost, "/auth/login", `{"email":"`+email+`","password":"secret1"}`, "", res); code != http.StatusOK {
+		s.t.Fatalf("login %s responded %d", email, code)
+	}
+	return res
+}
+
+// Registers user in groups and logs them in, so their token carries the groups
+func (s *testServer) registerInGroups(email string, groups ...string) *instance.AuthResult {
+	s.register(email)
+	if _, err := s.app.SetUserGroups(context.Background(), email, groups); err != nil {
+		s.t.Fatal(err)
+	}
+	return s.login(email)
+}
+
+// Creates project owned by the authenticated user
+func (s *testServer) createProject(token string, namespace string) {
+	if code, _ := s.do(http.MethodPost, "/projects", `{"namespace":"`+namespace+`"}`, token); code != http.StatusCreated {
+		s.t.Fatalf("creating project %s responded %d", namespace, code)
+	}
+}
+
+// Switches the active project and returns the new access token
+func (s *testServer) switchProject(token string, namespace string) string {
+	var switched = new(instance.Token)
+	if code := s.doJSON(http.MethodPost, "/auth/project", `{"project":"`+namespace+`"}`, token, switched); code != http.StatusOK {
+		s.t.Fatalf("switching to project %s responded %d", namespace, code)
+	}
+	return switched.Id
+}
+
+// Handlers run against store.Memory without App Engine
+func TestKindHandlersWithMemoryStore(t *testing.T) {
+	s := newTestServer(t, Options{Permissions: user.Permissions{user.PublicGroup: {"post:*"}}})
+	if err := s.app.Import(kind.New("post", []*kind.Field{{Name: "title", IsRequired: true, Worker: &field.Text{}}})); err != nil {
+		t.Fatal(err)
+	}
+
+	var request = func(method, path, body string) (int, map[string]interface{}) {
+		var out map[string]interface{}
+		code := s.doJSON(method, path, body, "", &out)
+		return code, out
+	}
+
+	code, added := request(http.MethodPost, "/post", `{"title":"a"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("add responded %d", code)
+	}
+	id, _ := added["id"].(string)
+
+	code, _ = request(http.MethodPut, "/post/"+id, `{}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("replace without required field responded %d", code)
+	}
+
+	code, got := request(http.MethodGet, "/post/"+id, "")
+	if code != http.StatusOK || got["title"] != "a" {
+		t.Errorf("get responded %d %v", code, got)
+	}
+
+	code, list := request(http.MethodGet, "/post", "")
+	if entries, _ := list["entries"].([]interface{}); code != http.StatusOK || len(entries) != 1 {
+		t.Errorf("list responded %d %v", code, list)
+	}
+}
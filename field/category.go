@@ -3,122 +3,79 @@ package field
 import (
 	"fmt"
 	"reflect"
-	"google.golang.org/appengine/datastore"
-	"golang.org/x/net/context"
+
 	"github.com/ales6164/go-cms/kind"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
 )
 
-type Category struct {
-	Name     string
-	Required bool
-	Multiple bool
-	NoIndex  bool
-	kind     *kind.Kind
+// Kind of categories Category fields refer to unless Category.Kind is set
+func NewCategories() *kind.Kind {
+	return kind.New("Category", []*kind.Field{
+		{Name: "name", IsRequired: true, Worker: &Text{}},
+	})
 }
 
-func (x *Category) Init() error {
-	return nil
+// Encoded key of a category entry; output is the category entry. The category kind is a sub kind
+// of kinds using the field, App.Import adds its routes.
+type Category struct {
+	Kind *kind.Kind // Default: NewCategories kind shared by kinds of the App
 }
 
-func (x *Category) RegisterSubKind() *kind.Kind {
-	x.kind = kind.New("categories")
+func (x *Category) Init(f *kind.Field) error {
+	if x.Kind == nil {
+		x.Kind = NewCategories()
+	}
 	return nil
 }
 
-func (x *Category) GetName() string {
-	return x.Name
+func (x *Category) SubKind() *kind.Kind {
+	return x.Kind
 }
 
-func (x *Category) GetRequired() bool {
-	return x.Required
+func (x *Category) NewSubKind() *kind.Kind {
+	return NewCategories()
 }
 
-func (x *Category) GetMultiple() bool {
-	return x.Multiple
+func (x *Category) SetSubKind(sub *kind.Kind) {
+	x.Kind = sub
 }
 
-func (x *Category) GetNoIndex() bool {
-	return x.NoIndex
+// Value is an encoded category key
+func (x *Category) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "string"}
 }
 
-func (x *Category) GetNested() bool {
-	return true
-}
-
-func (x *Category) Parse(value interface{}) ([]datastore.Property, error) {
-	var list []datastore.Property
-	if x.Multiple {
-		if multiArray, ok := value.([]interface{}); ok {
-			for _, value := range multiArray {
-				value, err := x.Check(value)
-				if err != nil {
-					return list, err
-				}
-				list = append(list, x.Property(value))
-			}
-		} else if value == nil {
-			value, err := x.Check(value)
-			if err != nil {
-				return list, err
-			}
-			list = append(list, x.Property(value))
-		} else {
-			return list, fmt.Errorf("field '%s' value type '%s' is not valid", x.Name, reflect.TypeOf(value).String())
+func (x *Category) Parse(f *kind.Field, value interface{}) ([]datastore.Property, error) {
+	return f.ParseValues(value, func(value interface{}) (interface{}, error) {
+		encoded, ok := value.(string)
+		if !ok {
+			return value, fmt.Errorf("field '%s' value type '%s' is not valid", f.Name, reflect.TypeOf(value).String())
 		}
-	} else {
-		value, err := x.Check(value)
-		if err != nil {
-			return list, err
+		key, err := datastore.DecodeKey(encoded)
+		if err != nil || key.Kind() != x.Kind.Name {
+			return value, fmt.Errorf("field '%s' value is not a %s key", f.Name, x.Kind.Name)
 		}
-		list = append(list, x.Property(value))
-	}
-	return list, nil
+		return key, nil
+	})
 }
 
-func (x *Category) Property(value interface{}) datastore.Property {
-	return datastore.Property{
-		Name:     x.Name,
-		Multiple: x.Multiple,
-		NoIndex:  x.NoIndex,
-		Value:    value,
+// Outputs category entry limited to user groups of the context; entries that can't be read and entries
+// from other namespaces than the context, e.g. another project, are output as encoded keys
+func (x *Category) Output(ctx context.Context, f *kind.Field, value interface{}) interface{} {
+	key, ok := value.(*datastore.Key)
+	if !ok {
+		return value
 	}
-}
-
-func (x *Category) Check(value interface{}) (interface{}, error) {
-	var err error
-	if value == nil {
-		if x.Required {
-			return value, fmt.Errorf("field '%s' value is required", x.Name)
-		}
-	} else {
-		err = x.Validate(value)
-		if err != nil {
-			return value, err
-		}
-		value, err = x.Transform(value)
+	if x.Kind.Store == nil || key.Namespace() != kind.ContextNamespace(ctx) {
+		return key.Encode()
 	}
-	return value, err
-}
-
-func (x *Category) Validate(value interface{}) error {
-	if _, ok := value.(string); ok {
-		return nil
+	h, err := x.Kind.Get(ctx, key)
+	if err != nil {
+		return key.Encode()
 	}
-	return fmt.Errorf("field '%s' value type '%s' is not valid", x.Name, reflect.TypeOf(value).String())
-}
-
-func (x *Category) Transform(value interface{}) (*datastore.Key, error) {
-	return datastore.DecodeKey(value.(string))
-}
-
-func (x *Category) Output(ctx context.Context, value interface{}) interface{} {
-	if key, ok := value.(*datastore.Key); ok {
-		var category category
-		datastore.Get(ctx, key, &category)
-		value = category
+	if groups, ok := kind.ContextGroups(ctx); ok {
+		h.SetGroups(groups)
 	}
-	return value
-}
-
-type category struct {
+	return h.Output()
 }
@@ -0,0 +1,102 @@
+package field_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// datastore.NewKey reads the app id from GAE_APPLICATION outside of App Engine
+func TestMain(m *testing.M) {
+	if len(os.Getenv("GAE_APPLICATION")) == 0 {
+		os.Setenv("GAE_APPLICATION", "dev~local")
+	}
+	os.Exit(m.Run())
+}
+
+// Category keys of another project are refused on save and never output as entries
+func TestCategoryOtherNamespace(t *testing.T) {
+	var s = store.NewMemory()
+	projectA, err := appengine.Namespace(context.Background(), "project-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectB, err := appengine.Namespace(context.Background(), "project-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	categories := kind.New("Category", []*kind.Field{
+		{Name: "name", IsRequired: true, Worker: &field.Text{}},
+		{Name: "secret", ReadGroups: []string{"admin"}, Worker: &field.Text{}},
+	})
+	categories.Store = s
+	k := kind.New("post", []*kind.Field{
+		{Name: "title", Worker: &field.Text{}},
+		{Name: "category", Worker: &field.Category{Kind: categories}},
+	})
+	k.Store = s
+	if err := k.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var addCategory = func(ctx context.Context) string {
+		c := categories.NewHolder(ctx, nil)
+		if err := c.ParseInput([]byte(`{"name":"News","secret":"s"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Add(); err != nil {
+			t.Fatal(err)
+		}
+		return c.Output()["id"].(string)
+	}
+	categoryA, categoryB := addCategory(projectA), addCategory(projectB)
+
+	h := k.NewHolder(projectA, nil)
+	err = h.ParseInput([]byte(`{"category":"` + categoryB + `"}`))
+	if _, ok := err.(*kind.KeyNamespaceError); !ok {
+		t.Fatalf("got %v for key of another project", err)
+	}
+
+	h = k.NewHolder(projectA, nil)
+	h.SetGroups([]string{"public"})
+	if err := h.ParseInput([]byte(`{"category":"` + categoryA + `"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Add(); err != nil {
+		t.Fatal(err)
+	}
+	category, ok := h.Output()["category"].(map[string]interface{})
+	if !ok || category["name"] != "News" {
+		t.Fatalf("got category %v", h.Output()["category"])
+	}
+	if _, ok := category["secret"]; ok {
+		t.Error("category field the caller can't read was output")
+	}
+
+	// keys stored before they were checked are output as keys
+	keyB, err := datastore.DecodeKey(categoryB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Put(projectA, datastore.NewIncompleteKey(projectA, k.Name, nil), &datastore.PropertyList{
+		{Name: "category", Value: keyB},
+		{Name: "meta.status", Value: "active"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders, _, err := k.List(projectA, store.NewQuery(k.Name).Filter("category", "=", keyB))
+	if err != nil || len(holders) != 1 {
+		t.Fatalf("got %d entries, %v", len(holders), err)
+	}
+	if out := holders[0].Output()["category"]; out != categoryB {
+		t.Errorf("category of another project was output as %v", out)
+	}
+}
@@ -3,101 +3,43 @@ package field
 import (
 	"fmt"
 	"reflect"
-	"google.golang.org/appengine/datastore"
-	"github.com/gosimple/slug"
-	"golang.org/x/net/context"
+
 	"github.com/ales6164/go-cms/kind"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
 )
 
-// Transforms text value into a slug string producing { text: originalValue, slug: newSlugValue }
-type Media struct {
-	Name     string
-	Required bool
-	Multiple bool
-	NoIndex  bool
-}
-
-func (x *Media) Init() error {
-	return nil
-}
+// Transforms text value into a slug string producing { text: originalValue, slug: newSlugValue };
+// multiple fields take an array of values
+type Media struct{}
 
-func (x *Media) RegisterSubKind() *kind.Kind {
+func (x *Media) Init(f *kind.Field) error {
 	return nil
 }
 
-func (x *Media) GetName() string {
-	return x.Name
-}
-
-func (x *Media) GetRequired() bool {
-	return x.Required
-}
-
-func (x *Media) GetMultiple() bool {
-	return x.Multiple
-}
-
-func (x *Media) GetNoIndex() bool {
-	return x.NoIndex
-}
-
-func (x *Media) GetNested() bool {
-	return true
+func (x *Media) JSONSchema() map[string]interface{} {
+	return textSlugSchema()
 }
 
-
-func (x *Media) Parse(value interface{}) ([]datastore.Property, error) {
-	var list []datastore.Property
-	var v map[string]interface{}
-
-	var err error
-	if value == nil {
-		if x.Required {
-			return list, fmt.Errorf("field '%s' value is required", x.Name)
-		}
-		return list, nil
-	} else {
-		v, err = x.Transform(value)
-	}
-	if err != nil {
-		return list, err
+func (x *Media) Parse(f *kind.Field, value interface{}) ([]datastore.Property, error) {
+	if !f.Multiple || value == nil {
+		return parseTextSlug(f, value, f.NoIndex)
 	}
-
-	valueText := v["text"].(string)
-	valueSlug := v["slug"].(string)
-
-	if len(valueText) == 0 {
-		return list, fmt.Errorf("field '%s' value[text] is required", x.Name)
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("field '%s' value type '%s' is not valid", f.Name, reflect.TypeOf(value).String())
 	}
-
-	if len(valueSlug) == 0 {
-		valueSlug = slug.Make(valueSlug)
+	var list []datastore.Property
+	for _, v := range values {
+		props, err := parseTextSlug(f, v, f.NoIndex)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, props...)
 	}
-
-	list = append(list, datastore.Property{
-		Name:     x.Name + ".text",
-		Multiple: x.Multiple,
-		NoIndex:  x.NoIndex,
-		Value:    valueText,
-	})
-	list = append(list, datastore.Property{
-		Name:     x.Name + ".slug",
-		Multiple: x.Multiple,
-		NoIndex:  x.NoIndex,
-		Value:    valueSlug,
-	})
-
 	return list, nil
 }
 
-func (x *Media) Transform(value interface{}) (map[string]interface{}, error) {
-	var v map[string]interface{}
-	if v, ok := value.(map[string]interface{}); ok {
-		return v, nil
-	}
-	return v, fmt.Errorf("field '%s' value type '%s' is not valid", x.Name, reflect.TypeOf(value).String())
-}
-
-func (x *Media) Output(ctx context.Context, value interface{}) interface{} {
+func (x *Media) Output(ctx context.Context, f *kind.Field, value interface{}) interface{} {
 	return value
 }
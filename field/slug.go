@@ -1,106 +1,89 @@
 package field
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
-	"google.golang.org/appengine/datastore"
+
+	"github.com/ales6164/go-cms/kind"
 	"github.com/gosimple/slug"
 	"golang.org/x/net/context"
-	"github.com/ales6164/go-cms/kind"
+	"google.golang.org/appengine/datastore"
 )
 
 // Transforms text value into a slug string producing { text: originalValue, slug: newSlugValue }
-type Slug struct {
-	Name     string
-	Required bool
-	Multiple bool
-	NoIndex  bool
-	Nested   bool
-}
+type Slug struct{}
 
-func (x *Slug) Init() error {
-	if x.Multiple {
-		return fmt.Errorf("field type Slug doesn't support multiple values")
+func (x *Slug) Init(f *kind.Field) error {
+	if f.Multiple {
+		return errors.New("field type Slug doesn't support multiple values")
 	}
 	return nil
 }
 
-func (x *Slug) RegisterSubKind() *kind.Kind {
-	return nil
+func (x *Slug) JSONSchema() map[string]interface{} {
+	return textSlugSchema()
 }
 
-func (x *Slug) GetName() string {
-	return x.Name
+func (x *Slug) Parse(f *kind.Field, value interface{}) ([]datastore.Property, error) {
+	return parseTextSlug(f, value, f.NoIndex)
 }
 
-func (x *Slug) GetRequired() bool {
-	return x.Required
-}
-
-func (x *Slug) GetMultiple() bool {
-	return x.Multiple
-}
-
-func (x *Slug) GetNoIndex() bool {
-	return x.NoIndex
-}
-
-func (x *Slug) GetNested() bool {
-	return x.Nested
+func (x *Slug) Output(ctx context.Context, f *kind.Field, value interface{}) interface{} {
+	return value
 }
 
-func (x *Slug) Parse(value interface{}) ([]datastore.Property, error) {
+// Parses { text: value, slug: value } input into name.text and name.slug properties;
+// slug is made from text when empty
+func parseTextSlug(f *kind.Field, value interface{}, noIndex bool) ([]datastore.Property, error) {
 	var list []datastore.Property
-	var v map[string]interface{}
-
-	var err error
 	if value == nil {
-		if x.Required {
-			return list, fmt.Errorf("field '%s' value is required", x.Name)
+		if f.IsRequired {
+			return list, fmt.Errorf("field '%s' value is required", f.Name)
 		}
 		return list, nil
-	} else {
-		v, err = x.Transform(value)
 	}
-	if err != nil {
-		return list, err
+
+	v, ok := value.(map[string]interface{})
+	if !ok {
+		return list, fmt.Errorf("field '%s' value type '%s' is not valid", f.Name, reflect.TypeOf(value).String())
 	}
 
-	valueText := v["text"].(string)
-	valueSlug := v["slug"].(string)
+	valueText, _ := v["text"].(string)
+	valueSlug, _ := v["slug"].(string)
 
 	if len(valueText) == 0 {
-		return list, fmt.Errorf("field '%s' value[text] is required", x.Name)
+		return list, fmt.Errorf("field '%s' value[text] is required", f.Name)
 	}
 
 	if len(valueSlug) == 0 {
-		valueSlug = slug.Make(valueSlug)
+		valueSlug = slug.Make(valueText)
 	}
 
 	list = append(list, datastore.Property{
-		Name:     x.Name + ".text",
-		Multiple: x.Multiple,
-		NoIndex:  x.NoIndex,
+		Name:     f.Name + ".text",
+		Multiple: f.Multiple,
+		NoIndex:  noIndex,
 		Value:    valueText,
 	})
 	list = append(list, datastore.Property{
-		Name:     x.Name + ".slug",
-		Multiple: x.Multiple,
-		NoIndex:  x.NoIndex,
+		Name:     f.Name + ".slug",
+		Multiple: f.Multiple,
+		NoIndex:  noIndex,
 		Value:    valueSlug,
 	})
 
 	return list, nil
 }
 
-func (x *Slug) Transform(value interface{}) (map[string]interface{}, error) {
-	var v map[string]interface{}
-	if v, ok := value.(map[string]interface{}); ok {
-		return v, nil
+// Schema of { text: value, slug: value } input
+func textSlugSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"text": map[string]interface{}{"type": "string", "minLength": 1},
+			"slug": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"text"},
 	}
-	return v, fmt.Errorf("field '%s' value type '%s' is not valid", x.Name, reflect.TypeOf(value).String())
-}
-
-func (x *Slug) Output(ctx context.Context, value interface{}) interface{} {
-	return value
 }
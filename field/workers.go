@@ -0,0 +1,12 @@
+package field
+
+import "github.com/ales6164/go-cms/kind"
+
+// Worker types available to kinds defined at runtime
+func init() {
+	kind.RegisterWorker("Text", func() kind.Worker { return &Text{} })
+	kind.RegisterWorker("Slug", func() kind.Worker { return &Slug{} })
+	kind.RegisterWorker("Article", func() kind.Worker { return &Article{} })
+	kind.RegisterWorker("Media", func() kind.Worker { return &Media{} })
+	kind.RegisterWorker("Category", func() kind.Worker { return &Category{} })
+}
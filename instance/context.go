@@ -21,6 +21,11 @@ type Context struct {
 	context.Context
 	UserKey          *datastore.Key
 	User             string
+	Groups           []string // user groups from token; PublicGroup is not included
+	Session          string   // session id the token was issued for
+	TwoFactor        bool     // token was issued for login with two-factor authentication
+	APIKey           string   // id of the API key the token belongs to
+	keyRules         user.Rules
 	Project          string
 	*body
 }
@@ -51,36 +56,53 @@ func (ctx Context) Id() string {
 	return mux.Vars(ctx.r)["id"]
 }
 
-func (ctx Context) HasPermission(e *Entity, scope Scope) (Context, error) {
-	if val1, ok := ctx.api.options.permissions[ctx.UserGroup()]; ok {
-		if val2, ok := val1[e.Name]; ok {
-			if val3, ok := val2[scope]; ok && val3 {
-				return ctx, nil
-			} else if val3, ok := val2["*"]; ok && val3 {
-				return ctx, nil
-			}
-		} else if val2, ok := val1["*"]; ok {
-			if val3, ok := val2[scope]; ok && val3 {
-				return ctx, nil
-			} else if val3, ok := val2["*"]; ok && val3 {
-				return ctx, nil
-			}
+func (ctx Context) hasScope(rules user.Rules, kindName string, scope user.Scope) bool {
+	if rules.HasScope(user.PublicGroup, kindName, scope) {
+		return true
+	}
+	for _, group := range ctx.Groups {
+		if rules.HasScope(group, kindName, scope) {
+			return true
 		}
 	}
+	return ctx.keyRules.HasScope(apiKeyGroup, kindName, scope)
+}
+
+// Checks if any of user groups or the public group has scope on kind; anonymous users get ErrUnathorized
+func (ctx Context) HasPermission(rules user.Rules, kindName string, scope user.Scope) error {
+	if ctx.hasScope(rules, kindName, scope) {
+		return nil
+	}
+	if !ctx.IsAuthenticated {
+		return ErrUnathorized
+	}
+	return ErrForbidden
+}
 
-	return ctx, ErrForbidden
+// Checks if authenticated user has scope on kind entries they created, e.g. "post:update:own"
+func (ctx Context) HasOwnPermission(rules user.Rules, kindName string, scope user.Scope) bool {
+	return ctx.IsAuthenticated && ctx.UserKey != nil && ctx.hasScope(rules, kindName, scope.Own())
 }
 
 // Authenticates user
 func (ctx Context) Authenticate() (bool, Context) {
 	var isAuthenticated, isExpired, hasProjectNamespace bool
-	var userEmail, projectNamespace string
+	var userEmail, projectNamespace, sessionId, apiKey string
+	var userGroups []string
+	var twoFactor bool
+	var keyRules user.Rules
 
 	tkn := gcontext.Get(ctx.r, "auth")
 	if tkn != nil {
 		token := tkn.(*jwt.Token)
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid && claims.VerifyAudience(TokenAudience, true) {
 			if err := claims.Valid(); err == nil {
+				userGroups = stringsClaim(claims, "grp")
+				sessionId, _ = claims["sid"].(string)
+				twoFactor, _ = claims["mfa"].(bool)
+				if apiKey, _ = claims["akid"].(string); len(apiKey) > 0 {
+					keyRules = apiKeyRules(claims)
+				}
 				if projectNamespace, ok = claims["pro"].(string); ok && len(projectNamespace) > 0 {
 					hasProjectNamespace = true
 				}
@@ -106,11 +128,18 @@ func (ctx Context) Authenticate() (bool, Context) {
 	if ctx.IsAuthenticated {
 		ctx.HasProjectAccess = hasProjectNamespace
 		ctx.User = userEmail
+		ctx.Groups = userGroups
+		ctx.Session = sessionId
+		ctx.TwoFactor = twoFactor
 		ctx.Project = projectNamespace
+		ctx.APIKey = apiKey
+		ctx.keyRules = keyRules
 		ctx.UserKey = datastore.NewKey(ctx, "User", userEmail, 0, nil)
 	} else {
 		ctx.HasProjectAccess = false
 		ctx.User = ""
+		ctx.Groups = nil
+		ctx.Session = ""
 		ctx.Project = ""
 	}
 
@@ -120,14 +149,20 @@ func (ctx Context) Authenticate() (bool, Context) {
 // Authenticates user; if token is expired, returns a renewed unsigned *jwt.Token
 func (ctx Context) Renew() (Context, *jwt.Token) {
 	var isAuthenticated, hasProjectNamespace bool
-	var userEmail, projectNamespace string
+	var userEmail, projectNamespace, sessionId string
+	var userGroups []string
+	var twoFactor bool
 	var unsignedToken *jwt.Token
 
 	tkn := gcontext.Get(ctx.r, "auth")
 	if tkn != nil {
 		token := tkn.(*jwt.Token)
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		// API keys don't expire and can't be exchanged for user tokens
+		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid && claims.VerifyAudience(TokenAudience, true) && claims["akid"] == nil {
+			userGroups = stringsClaim(claims, "grp")
+			sessionId, _ = claims["sid"].(string)
+			twoFactor, _ = claims["mfa"].(bool)
 
 			if err := claims.Valid(); err == nil {
 				if projectNamespace, ok = claims["pro"].(string); ok && len(projectNamespace) > 0 {
@@ -137,8 +172,8 @@ func (ctx Context) Renew() (Context, *jwt.Token) {
 					isAuthenticated = true
 				}
 			} else if exp, ok := claims["exp"].(float64); ok {
-				// check if it's less than a week old
-				if time.Now().Unix()-int64(exp) < time.Now().Add(time.Hour * 24 * 7).Unix() {
+				// check if it expired less than RenewGracePeriod ago
+				if time.Now().Unix()-int64(exp) < int64(RenewGracePeriod/time.Second) {
 					if projectNamespace, ok = claims["pro"].(string); ok && len(projectNamespace) > 0 {
 						hasProjectNamespace = true
 					}
@@ -152,6 +187,9 @@ func (ctx Context) Renew() (Context, *jwt.Token) {
 
 	ctx.IsAuthenticated = isAuthenticated
 	ctx.User = userEmail
+	ctx.Groups = userGroups
+	ctx.Session = sessionId
+	ctx.TwoFactor = twoFactor
 
 	vars := mux.Vars(ctx.r)
 	newProjectNamespace := vars["project"]
@@ -165,22 +203,62 @@ func (ctx Context) Renew() (Context, *jwt.Token) {
 
 	// issue a new token
 	if isAuthenticated {
-		unsignedToken = NewToken(ctx.User, ctx.Project)
+		unsignedToken = NewToken(ctx.User, ctx.Project, ctx.Groups)
+		if len(ctx.Session) > 0 {
+			unsignedToken.Claims.(jwt.MapClaims)["sid"] = ctx.Session
+		}
+		if ctx.TwoFactor {
+			unsignedToken.Claims.(jwt.MapClaims)["mfa"] = true
+		}
 	}
 
 	return ctx, unsignedToken
 }
 
-func NewToken(userEmail string, projectNamespace string) *jwt.Token {
-	var exp = time.Now().Add(time.Hour * 72).Unix()
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	var values []string
+	if claim, ok := claims[name].([]interface{}); ok {
+		for _, v := range claim {
+			if value, ok := v.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// group API key permissions are granted to in its rules
+const apiKeyGroup = "apikey"
+
+// Rules from API key "scp" claim with "kind:scope" permissions
+func apiKeyRules(claims jwt.MapClaims) user.Rules {
+	var perms = user.Permissions{apiKeyGroup: stringsClaim(claims, "scp")}
+	if perms.Validate() != nil {
+		return nil
+	}
+	return perms.Parse()
+}
+
+// How long after expiration a token can still be renewed
+var RenewGracePeriod = time.Hour * 24 * 7
+
+// Token lifetime used by NewToken; tokens are renewed with refresh tokens
+var DefaultTokenLifetime = time.Hour
+
+// Audience of access tokens; tokens issued for other purposes don't authenticate requests
+const TokenAudience = "api"
+
+func NewToken(userEmail string, projectNamespace string, userGroups []string) *jwt.Token {
+	var exp = time.Now().Add(DefaultTokenLifetime).Unix()
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"aud": "api",
+		"aud": TokenAudience,
 		"nbf": time.Now().Add(-time.Minute).Unix(),
 		"exp": exp,
 		"iat": time.Now().Unix(),
 		"iss": "sdk",
 		"sub": userEmail,
 		"pro": projectNamespace,
+		"grp": userGroups,
 	})
 }
 
@@ -194,22 +272,35 @@ type Token struct {
 }
 
 type AuthResult struct {
-	Token *Token     `json:"token"`
-	User  *user.User `json:"user"`
+	Token        *Token     `json:"token"`
+	RefreshToken *Token     `json:"refreshToken,omitempty"`
+	User         *user.User `json:"user"`
 }
 
 func (ctx *Context) PrintResult(w http.ResponseWriter, result interface{}) {
+	ctx.PrintStatus(w, http.StatusOK, result)
+}
+
+// Prints result with a custom status code; nil result is written only as a status code
+func (ctx *Context) PrintStatus(w http.ResponseWriter, status int, result interface{}) {
+	if result == nil {
+		w.WriteHeader(status)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 
 	json.NewEncoder(w).Encode(result)
 }
 
-func (ctx *Context) PrintAuth(w http.ResponseWriter, user *user.User, token *Token) {
+func (ctx *Context) PrintAuth(w http.ResponseWriter, user *user.User, token *Token, refreshToken *Token) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var out = AuthResult{
-		User:  user,
-		Token: token,
+		User:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 
 	json.NewEncoder(w).Encode(out)
@@ -218,6 +309,12 @@ func (ctx *Context) PrintAuth(w http.ResponseWriter, user *user.User, token *Tok
 func (ctx *Context) PrintError(w http.ResponseWriter, err error) {
 	if err == ErrUnathorized {
 		w.WriteHeader(http.StatusUnauthorized)
+	} else if err == ErrForbidden {
+		w.WriteHeader(http.StatusForbidden)
+	} else if err == datastore.ErrNoSuchEntity {
+		w.WriteHeader(http.StatusNotFound)
+	} else if err == ErrLoginLocked {
+		w.WriteHeader(http.StatusTooManyRequests)
 	} else if _, ok := err.(*Error); ok {
 		w.WriteHeader(http.StatusBadRequest)
 	} else {
@@ -29,6 +29,29 @@ var (
 	ErrUserAlreadyExists     = NewError("user with that email already exists", 107)
 	ErrInvalidFormInput      = NewError("invalid form input", 108)
 	ErrProjectAlreadyExists  = NewError("project already exists", 109)
+	ErrInvalidId             = NewError("entry id is not valid", 110)
+	ErrInvalidQuery          = NewError("invalid query parameter", 111)
+	ErrFieldNotIndexed       = NewError("field is not indexed", 112)
+	ErrInvalidGroup          = NewError("group name is not valid", 113)
+	ErrFieldForbidden        = NewError("field can't be written", 114)
+	ErrInvalidToken          = NewError("token is not valid or has expired", 115)
+	ErrEmailNotVerified      = NewError("email is not verified", 116)
+	ErrLoginLocked           = NewError("too many failed login attempts, try again later", 117)
+	ErrIdentityLogin         = NewError("identity provider login failed", 118)
+	ErrTwoFactorCode         = NewError("two-factor code is not valid", 119)
+	ErrTwoFactorEnabled      = NewError("two-factor authentication is already enabled", 120)
+	ErrTwoFactorNotEnabled   = NewError("two-factor authentication is not enabled", 121)
+	ErrInvalidPermission     = NewError("permission is not valid", 122)
+	ErrUserDisabled          = NewError("user account is disabled", 123)
+	ErrInvalidProject        = NewError("project namespace must be 3 to 63 lowercase letters, digits or dashes", 124)
+	ErrProjectMember         = NewError("user is already a project member", 125)
+	ErrProjectOwnerRole      = NewError("owner role can only be changed by transferring ownership", 126)
+	ErrInvalidRole           = NewError("project role is not valid", 127)
+	ErrInvalidKind           = NewError("kind is not valid", 128)
+	ErrKindAlreadyExists     = NewError("kind already exists", 129)
+	ErrInvalidMigration      = NewError("migration steps are not valid", 130)
+	ErrFieldRequired         = NewError("field value is required", 131)
+	ErrFieldKeyNamespace     = NewError("field value is a key from another project", 132)
 	ErrUnathorized           = errors.New("unathorized")
 	ErrForbidden             = errors.New("action forbidden")
 )
@@ -0,0 +1,60 @@
+package kind
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Machine readable description of a kind, e.g. for rendering forms
+type Definition struct {
+	Name     string             `json:"name"`
+	Fields   []*FieldDefinition `json:"fields"`
+	SubKinds []*Definition      `json:"subKinds,omitempty"`
+}
+
+type FieldDefinition struct {
+	Name     string   `json:"name"`
+	Path     []string `json:"path"`           // name split on dots; nested fields have more than one element
+	Type     string   `json:"type,omitempty"` // worker type, e.g. "Text"; empty when field has no worker
+	Required bool     `json:"required"`
+	Multiple bool     `json:"multiple"`
+	NoIndex  bool     `json:"noIndex"`
+	Nested   bool     `json:"nested"`
+	ReadOnly bool     `json:"readOnly"` // user groups can't write the field
+}
+
+// Describes kind as seen by user groups; fields they can't read are left out
+func (k *Kind) Definition(groups []string) *Definition {
+	var def = &Definition{Name: k.Name, Fields: []*FieldDefinition{}}
+	for _, f := range k.Fields {
+		if !f.CanRead(groups) {
+			continue
+		}
+		def.Fields = append(def.Fields, &FieldDefinition{
+			Name:     f.Name,
+			Path:     strings.Split(f.Name, "."),
+			Type:     WorkerType(f.Worker),
+			Required: f.IsRequired,
+			Multiple: f.Multiple,
+			NoIndex:  f.NoIndex,
+			Nested:   f.isNested,
+			ReadOnly: !f.CanWrite(groups),
+		})
+	}
+	for _, sub := range k.subKinds {
+		def.SubKinds = append(def.SubKinds, sub.Definition(groups))
+	}
+	return def
+}
+
+// Name of the worker type without package and pointer, e.g. "Text" for *field.Text
+func WorkerType(w Worker) string {
+	if w == nil {
+		return ""
+	}
+	t := reflect.TypeOf(w)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
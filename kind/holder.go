@@ -4,8 +4,8 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
 	"time"
-	"errors"
 	"encoding/json"
+	"sort"
 	"strings"
 )
 
@@ -21,6 +21,70 @@ type Holder struct {
 	datastoreData       []datastore.Property            // list of properties stored in datastore - refreshed on Load or Save
 
 	isOldVersion bool // when updating entity we want to also update old entry meta.
+	isReplacing  bool // when replacing entity stored field values are not kept
+
+	hasGroups bool     // field read and write groups are checked only when user groups are set
+	groups    []string // user groups
+}
+
+// Returned by ParseInput when user groups can't write to a field
+type FieldPermissionError struct {
+	Name string
+}
+
+func (e *FieldPermissionError) Error() string {
+	return "field '" + e.Name + "' can't be written"
+}
+
+// Returned by Save when a required field has neither input nor stored value
+type RequiredFieldError struct {
+	Name string
+}
+
+func (e *RequiredFieldError) Error() string {
+	return "field '" + e.Name + "' value is required"
+}
+
+// Returned by ParseInput when a key value is from another namespace than the entry, e.g. another project
+type KeyNamespaceError struct {
+	Name string
+}
+
+func (e *KeyNamespaceError) Error() string {
+	return "field '" + e.Name + "' value is a key from another namespace"
+}
+
+type groupsContextKey struct{}
+
+// Returns context carrying user groups; workers outputting other entries limit them to the same groups
+func WithGroups(ctx context.Context, groups []string) context.Context {
+	return context.WithValue(ctx, groupsContextKey{}, groups)
+}
+
+// User groups set by WithGroups
+func ContextGroups(ctx context.Context) ([]string, bool) {
+	groups, ok := ctx.Value(groupsContextKey{}).([]string)
+	return groups, ok
+}
+
+// Datastore namespace of context
+func ContextNamespace(ctx context.Context) string {
+	return datastore.NewIncompleteKey(ctx, "_", nil).Namespace()
+}
+
+// Limits fields to those user groups can read and write; without groups all fields are accessible
+func (h *Holder) SetGroups(groups []string) {
+	h.hasGroups = true
+	h.groups = groups
+	h.context = WithGroups(h.context, groups)
+}
+
+func (h *Holder) canRead(f *Field) bool {
+	return !h.hasGroups || f == nil || f.CanRead(h.groups)
+}
+
+func (h *Holder) canWrite(f *Field) bool {
+	return !h.hasGroups || f == nil || f.CanWrite(h.groups)
 }
 
 func (h *Holder) ParseInput(body []byte) error {
@@ -34,29 +98,42 @@ func (h *Holder) ParseInput(body []byte) error {
 
 		// check for input
 		if value, ok := m[f.Name]; ok {
+			if !h.canWrite(f) {
+				return &FieldPermissionError{Name: f.Name}
+			}
 
 			props, err := f.Parse(value)
 			if err != nil {
 				return err
 			}
+			if err := h.checkKeyNamespaces(f, props); err != nil {
+				return err
+			}
 
 			h.preparedInputData[f] = props
 		} else {
 			names := strings.Split(f.Name, ".")
 			if _, ok := m[names[0]]; ok {
+				if !h.canWrite(f) {
+					return &FieldPermissionError{Name: f.Name}
+				}
 
 				var endValue = m[names[0]]
 				for i := 1; i < len(names); i++ {
 					if nestedMap, ok := endValue.(map[string]interface{}); ok {
 						endValue = nestedMap[names[i]]
 					} else {
-						continue
+						endValue = nil
+						break
 					}
 				}
-				props, err := f.Parse(value)
+				props, err := f.Parse(endValue)
 				if err != nil {
 					return err
 				}
+				if err := h.checkKeyNamespaces(f, props); err != nil {
+					return err
+				}
 				h.preparedInputData[f] = props
 			}
 		}
@@ -64,6 +141,17 @@ func (h *Holder) ParseInput(body []byte) error {
 	return nil
 }
 
+// key values can only refer to entries in the namespace of the holder
+func (h *Holder) checkKeyNamespaces(f *Field, props []datastore.Property) error {
+	var namespace = ContextNamespace(h.context)
+	for _, prop := range props {
+		if key, ok := prop.Value.(*datastore.Key); ok && key.Namespace() != namespace {
+			return &KeyNamespaceError{Name: f.Name}
+		}
+	}
+	return nil
+}
+
 // appends value
 func (h *Holder) appendValue(dst interface{}, field *Field, value interface{}, multiple bool) interface{} {
 	value = field.Output(h.context, value)
@@ -101,6 +189,9 @@ func (h *Holder) Output() map[string]interface{} {
 
 	// range over data. Value can be single value or if the field it Multiple then it's an array
 	for _, prop := range h.datastoreData {
+		if !h.canRead(h.Kind.fieldOf(prop.Name)) {
+			continue
+		}
 		output = h.appendPropertyValue(output, prop, h.Kind.fields[prop.Name])
 	}
 
@@ -109,6 +200,16 @@ func (h *Holder) Output() map[string]interface{} {
 	return output
 }
 
+// Returns user key stored in meta.createdBy of a loaded entry
+func (h *Holder) CreatedBy() *datastore.Key {
+	if props, ok := h.loadedStoredData["meta.createdBy"]; ok && len(props) > 0 {
+		if key, ok := props[0].Value.(*datastore.Key); ok {
+			return key
+		}
+	}
+	return nil
+}
+
 func (h *Holder) Load(ps []datastore.Property) error {
 	h.hasLoadedStoredData = true
 	h.datastoreData = ps
@@ -127,16 +228,16 @@ func (h *Holder) Save() ([]datastore.Property, error) {
 	for _, f := range h.Kind.Fields {
 
 		var inputProperties = h.preparedInputData[f]
-		var loadedProperties = h.loadedStoredData[f.Name]
+		var loadedProperties = h.loadedFieldProperties(f)
 
 		var toSaveProps []datastore.Property
 
 		if len(inputProperties) != 0 {
 			toSaveProps = append(toSaveProps, inputProperties...)
-		} else if len(loadedProperties) != 0 {
+		} else if len(loadedProperties) != 0 && (!h.isReplacing || !h.canWrite(f)) {
 			toSaveProps = append(toSaveProps, loadedProperties...)
 		} else if f.IsRequired {
-			return nil, errors.New("field " + f.Name + " required")
+			return nil, &RequiredFieldError{Name: f.Name}
 		}
 
 		h.datastoreData = append(h.datastoreData, toSaveProps...)
@@ -197,6 +298,23 @@ func (h *Holder) Save() ([]datastore.Property, error) {
 	return ps, nil
 }
 
+// Stored properties of field including properties of its worker (name.text, name.slug)
+func (h *Holder) loadedFieldProperties(f *Field) []datastore.Property {
+	var names []string
+	for name := range h.loadedStoredData {
+		if h.Kind.fieldOf(name) == f {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var props []datastore.Property
+	for _, name := range names {
+		props = append(props, h.loadedStoredData[name]...)
+	}
+	return props
+}
+
 type HolderOld struct {
 	data *Holder
 	key  *datastore.Key
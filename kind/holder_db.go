@@ -3,21 +3,46 @@ package kind
 import (
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
+	"github.com/ales6164/go-cms/store"
 )
 
 func (k *Kind) Get(ctx context.Context, key *datastore.Key) (*Holder, error) {
 	var h = k.NewHolder(ctx, nil)
 	h.key = key
 
-	err := datastore.Get(ctx, key, h)
+	err := k.Store.Get(ctx, key, h)
 	return h, err
 }
 
+// Runs query over current kind entries; old versions stored by Update are left out
+func (k *Kind) List(ctx context.Context, q *store.Query) ([]*Holder, string, error) {
+	var holders []*Holder
+
+	q.Kind = k.Name
+	q.Filter("meta.status", "=", "active")
+
+	entities, cursor, err := k.Store.Run(ctx, q)
+	if err != nil {
+		return holders, "", err
+	}
+
+	for _, e := range entities {
+		var h = k.NewHolder(ctx, nil)
+		h.key = e.Key
+		if err := h.Load(e.Properties); err != nil {
+			return holders, "", err
+		}
+		holders = append(holders, h)
+	}
+
+	return holders, cursor, nil
+}
+
 func (h *Holder) Add() error {
 	var err error
 
 	h.key = h.Kind.NewIncompleteKey(h.context, nil)
-	h.key, err = datastore.Put(h.context, h.key, h)
+	h.key, err = h.Kind.Store.Put(h.context, h.key, h)
 	if err != nil {
 		return err
 	}
@@ -29,8 +54,8 @@ func (h *Holder) Add() error {
 
 func (h *Holder) Update(key *datastore.Key) error {
 	h.key = key
-	err := datastore.RunInTransaction(h.context, func(tc context.Context) error {
-		err := datastore.Get(tc, h.key, h)
+	err := h.Kind.Store.RunInTransaction(h.context, func(tc context.Context) error {
+		err := h.Kind.Store.Get(tc, h.key, h)
 		if err != nil {
 			return err
 		}
@@ -41,18 +66,25 @@ func (h *Holder) Update(key *datastore.Key) error {
 		var keys = []*datastore.Key{replacementKey, h.key}
 		var holders = []interface{}{oldHolder, h}
 
-		keys, err = datastore.PutMulti(tc, keys, holders)
+		keys, err = h.Kind.Store.PutMulti(tc, keys, holders)
 		return err
-	}, &datastore.TransactionOptions{XG: true})
+	})
 
 	//dataHolder.updateSearchIndex()
 
 	return err
 }
 
+// Replace works as Update, but fields missing from input are removed instead of kept
+func (h *Holder) Replace(key *datastore.Key) error {
+	h.isReplacing = true
+	defer func() { h.isReplacing = false }()
+	return h.Update(key)
+}
+
 func (h *Holder) Delete(key *datastore.Key) error {
 	h.key = key
-	err := datastore.Delete(h.context, h.key)
+	err := h.Kind.Store.Delete(h.context, h.key)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,79 @@
+package kind
+
+import "strings"
+
+const JSONSchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// Worker describing values it accepts; fields with other workers accept any value
+type SchemaWorker interface {
+	JSONSchema() map[string]interface{}
+}
+
+// JSON Schema of kind entry input as seen by user groups; nested field names (author.name) are nested objects
+func (k *Kind) JSONSchema(groups []string) map[string]interface{} {
+	schema := k.ObjectSchema(groups)
+	schema["$schema"] = JSONSchemaVersion
+	schema["title"] = k.Name
+	return schema
+}
+
+// JSON Schema object of kind fields without $schema, e.g. for embedding into OpenAPI documents;
+// fields user groups can't read are left out
+func (k *Kind) ObjectSchema(groups []string) map[string]interface{} {
+	var root = objectSchema()
+	for _, f := range k.Fields {
+		if !f.CanRead(groups) {
+			continue
+		}
+		names := strings.Split(f.Name, ".")
+		parent := root
+		for _, name := range names[:len(names)-1] {
+			props := parent["properties"].(map[string]interface{})
+			child, ok := props[name].(map[string]interface{})
+			if !ok {
+				child = objectSchema()
+				props[name] = child
+			}
+			if f.IsRequired {
+				addRequired(parent, name)
+			}
+			parent = child
+		}
+
+		name := names[len(names)-1]
+		parent["properties"].(map[string]interface{})[name] = f.valueSchema()
+		if f.IsRequired {
+			addRequired(parent, name)
+		}
+	}
+	return root
+}
+
+// Schema of field value; multiple fields take an array of values
+func (f *Field) valueSchema() map[string]interface{} {
+	var schema = map[string]interface{}{}
+	if w, ok := f.Worker.(SchemaWorker); ok {
+		schema = w.JSONSchema()
+	}
+	if f.Multiple {
+		return map[string]interface{}{"type": "array", "items": schema}
+	}
+	return schema
+}
+
+func objectSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{},
+	}
+}
+
+func addRequired(schema map[string]interface{}, name string) {
+	required, _ := schema["required"].([]string)
+	for _, r := range required {
+		if r == name {
+			return
+		}
+	}
+	schema["required"] = append(required, name)
+}
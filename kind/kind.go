@@ -3,59 +3,154 @@ package kind
 import (
 	"errors"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
 	"github.com/asaskevich/govalidator"
+	"github.com/ales6164/go-cms/store"
 )
 
 type Kind struct {
-	Name   string  `json:"name"` // Only a-Z characters allowed
-	Fields []*Field `json:"fields"`
+	Name   string      `json:"name"` // Only a-Z characters allowed
+	Fields []*Field    `json:"fields"`
+	Store  store.Store `json:"-"` // set by App.Import if empty
 
-	subKinds []*Kind // kinds managed by fields
-	fields   map[string]*Field
+	subKinds    []*Kind // kinds managed by fields
+	fields      map[string]*Field
+	initMu      sync.Mutex // kinds sharing a sub kind initialize it concurrently
+	initialized bool
 }
 
 type Field struct {
-	Name       string
-	IsRequired bool
-	Multiple   bool
-	NoIndex    bool
+	Name        string
+	IsRequired  bool
+	Multiple    bool
+	NoIndex     bool
+	ReadGroups  []string // user groups allowed to read the field; empty allows everyone
+	WriteGroups []string // user groups allowed to write the field; empty allows everyone
 
 	isNested bool
 	Worker
 }
 
 func New(name string, fields []*Field) *Kind {
-	if !govalidator.IsAlpha(name) {
-		panic(errors.New("kind name must contain a-zA-Z characters only"))
+	if err := check(name, fields); err != nil {
+		panic(err)
 	}
 	k := new(Kind)
 	k.Name = name
 	k.Fields = fields
+	for _, f := range fields {
+		f.isNested = strings.Contains(f.Name, ".")
+		if k.fields == nil {
+			k.fields = map[string]*Field{}
+		}
+		k.fields[f.Name] = f
+	}
+	return k
+}
+
+// Initializes field workers and adds kinds they manage to SubKinds; App.Import calls it.
+// Kind is initialized once, calling Init again returns nil.
+func (k *Kind) Init() error {
+	k.initMu.Lock()
+	defer k.initMu.Unlock()
+	if k.initialized {
+		return nil
+	}
+	for _, f := range k.Fields {
+		if f.Worker == nil {
+			continue
+		}
+		if err := f.Worker.Init(f); err != nil {
+			return errors.New("kind '" + k.Name + "' field '" + f.Name + "': " + err.Error())
+		}
+		if w, ok := f.Worker.(SubKindWorker); ok {
+			if sub := w.SubKind(); sub != nil {
+				if err := sub.Init(); err != nil {
+					return err
+				}
+				k.addSubKind(sub)
+			}
+		}
+	}
+	k.initialized = true
+	return nil
+}
+
+func (k *Kind) addSubKind(sub *Kind) {
+	for _, s := range k.subKinds {
+		if s == sub {
+			return
+		}
+	}
+	k.subKinds = append(k.subKinds, sub)
+}
+
+// Checks kind and field names
+func check(name string, fields []*Field) error {
+	if !govalidator.IsAlpha(name) {
+		return errors.New("kind name must contain a-zA-Z characters only")
+	}
+	var names = map[string]bool{}
 	for _, f := range fields {
 		if len(f.Name) == 0 {
-			panic(errors.New("field name can't be empty"))
+			return errors.New("field name can't be empty")
+		}
+		if names[f.Name] {
+			return errors.New("field '" + f.Name + "' is defined more than once")
 		}
+		names[f.Name] = true
 		if f.Name == "meta" || f.Name == "id" {
-			panic(errors.New("field name '" + f.Name + "' already exists"))
+			return errors.New("field name '" + f.Name + "' already exists")
 		}
 		if f.Name[:1] == "_" {
-			panic(errors.New("field name can't begin with an underscore"))
+			return errors.New("field name can't begin with an underscore")
 		}
 		if split := strings.Split(f.Name, "."); len(split) > 1 {
 			if split[0] == "meta" || split[0] == "id" {
-				panic(errors.New("field name '" + f.Name + "' already exists"))
+				return errors.New("field name '" + f.Name + "' already exists")
 			}
-			f.isNested = true
 		}
-		if k.fields == nil {
-			k.fields = map[string]*Field{}
+	}
+	return nil
+}
+
+// Returns field the property belongs to; nested worker properties (name.text) belong to their field (name)
+func (k *Kind) fieldOf(propName string) *Field {
+	for name := propName; ; {
+		if f, ok := k.fields[name]; ok {
+			return f
 		}
-		k.fields[f.Name] = f
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return nil
+		}
+		name = name[:i]
 	}
-	return k
+}
+
+func (f *Field) CanRead(groups []string) bool {
+	return hasGroup(f.ReadGroups, groups)
+}
+
+func (f *Field) CanWrite(groups []string) bool {
+	return hasGroup(f.WriteGroups, groups)
+}
+
+func hasGroup(allowed []string, groups []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		for _, g := range groups {
+			if a == g {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (k *Kind) NewHolder(ctx context.Context, user *datastore.Key) *Holder {
@@ -68,6 +163,12 @@ func (k *Kind) NewHolder(ctx context.Context, user *datastore.Key) *Holder {
 	}
 }
 
+// Returns field with the name as given to New
+func (k *Kind) Field(name string) (*Field, bool) {
+	f, ok := k.fields[name]
+	return f, ok
+}
+
 func (k *Kind) SubKinds() []*Kind {
 	return k.subKinds
 }
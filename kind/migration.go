@@ -0,0 +1,521 @@
+package kind
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const DefaultMigrationBatchSize = 100
+
+// Changes stored properties of an entry; k is the kind with the new fields.
+// Steps return properties unchanged when they don't apply to the entry.
+type Step interface {
+	Apply(k *Kind, ps []datastore.Property) ([]datastore.Property, error)
+}
+
+type StepFunc func(k *Kind, ps []datastore.Property) ([]datastore.Property, error)
+
+func (f StepFunc) Apply(k *Kind, ps []datastore.Property) ([]datastore.Property, error) {
+	return f(k, ps)
+}
+
+// Renames field; nested properties (from.text) are renamed with it and take Multiple and NoIndex of the new field
+func RenameField(from, to string) Step {
+	return StepFunc(func(k *Kind, ps []datastore.Property) ([]datastore.Property, error) {
+		f, ok := k.fields[to]
+		if !ok {
+			return nil, errors.New("field '" + to + "' is not defined")
+		}
+		var out = make([]datastore.Property, len(ps))
+		for i, p := range ps {
+			if belongsTo(k, p.Name, from) {
+				p.Name = to + p.Name[len(from):]
+				p.Multiple = f.Multiple
+				p.NoIndex = f.NoIndex
+			}
+			out[i] = p
+		}
+		return out, nil
+	})
+}
+
+// Replaces field with fields set to values split returns for the stored value; split returns a value
+// for each of the new fields. Entries without the field are left as they are.
+func SplitField(from string, to []string, split func(value interface{}) ([]interface{}, error)) Step {
+	return StepFunc(func(k *Kind, ps []datastore.Property) ([]datastore.Property, error) {
+		value, ok := fieldValue(k, ps, from)
+		if !ok {
+			return ps, nil
+		}
+		values, err := split(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != len(to) {
+			return nil, errors.New("field '" + from + "' split into wrong number of values")
+		}
+		ps = removeField(k, ps, from)
+		for i, name := range to {
+			ps, err = setField(k, ps, name, values[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return ps, nil
+	})
+}
+
+// Replaces fields with field set to value merge returns for stored values; missing fields are nil.
+// Entries without any of the fields are left as they are.
+func MergeFields(from []string, to string, merge func(values []interface{}) (interface{}, error)) Step {
+	return StepFunc(func(k *Kind, ps []datastore.Property) ([]datastore.Property, error) {
+		var values = make([]interface{}, len(from))
+		var found bool
+		for i, name := range from {
+			var ok bool
+			values[i], ok = fieldValue(k, ps, name)
+			found = found || ok
+		}
+		if !found {
+			return ps, nil
+		}
+		value, err := merge(values)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range from {
+			if name != to {
+				ps = removeField(k, ps, name)
+			}
+		}
+		return setField(k, ps, to, value)
+	})
+}
+
+// Converts stored value of field and stores it with Multiple and NoIndex of the field. Multiple values
+// are passed as []interface{}; convert can be nil when only Multiple or NoIndex changed.
+func ChangeType(name string, convert func(value interface{}) (interface{}, error)) Step {
+	return StepFunc(func(k *Kind, ps []datastore.Property) ([]datastore.Property, error) {
+		value, ok := fieldValue(k, ps, name)
+		if !ok {
+			return ps, nil
+		}
+		if convert != nil {
+			var err error
+			value, err = convert(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return setField(k, ps, name, value)
+	})
+}
+
+// Sets field to value on entries without it
+func Backfill(name string, value interface{}) Step {
+	return StepFunc(func(k *Kind, ps []datastore.Property) ([]datastore.Property, error) {
+		if _, ok := fieldValue(k, ps, name); ok {
+			return ps, nil
+		}
+		return setField(k, ps, name, value)
+	})
+}
+
+// Tells if stored property belongs to field name, including worker properties (name.text);
+// properties of nested fields defined in k (author.name) don't belong to author
+func belongsTo(k *Kind, propName, name string) bool {
+	if propName != name && !strings.HasPrefix(propName, name+".") {
+		return false
+	}
+	f := k.fieldOf(propName)
+	return f == nil || len(f.Name) <= len(name)
+}
+
+// Returns stored value of field as it would be input; values of multiple properties are returned
+// as []interface{} and worker properties (name.text, name.slug) as objects ({text, slug})
+func fieldValue(k *Kind, ps []datastore.Property, name string) (interface{}, bool) {
+	var values []interface{}
+	var subs = map[string][]interface{}{}
+	var multiple, found bool
+	for _, p := range ps {
+		if !belongsTo(k, p.Name, name) {
+			continue
+		}
+		found = true
+		multiple = multiple || p.Multiple
+		if p.Name == name {
+			values = append(values, p.Value)
+		} else {
+			sub := p.Name[len(name)+1:]
+			subs[sub] = append(subs[sub], p.Value)
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	var n int
+	for _, vs := range subs {
+		if len(vs) > n {
+			n = len(vs)
+		}
+	}
+	for i := 0; i < n; i++ {
+		var m = map[string]interface{}{}
+		for sub, vs := range subs {
+			if i < len(vs) {
+				setPath(m, strings.Split(sub, "."), vs[i])
+			}
+		}
+		values = append(values, m)
+	}
+
+	if !multiple && len(values) == 1 {
+		return values[0], true
+	}
+	return values, true
+}
+
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := m[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[name] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+func removeField(k *Kind, ps []datastore.Property, name string) []datastore.Property {
+	var out []datastore.Property
+	for _, p := range ps {
+		if !belongsTo(k, p.Name, name) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Replaces field properties with value parsed by the field; properties keep position of the replaced ones
+// and nil value removes them. Single value of a multiple field is stored as its only value and a list
+// of one value is stored as single value.
+func setField(k *Kind, ps []datastore.Property, name string, value interface{}) ([]datastore.Property, error) {
+	f, ok := k.fields[name]
+	if !ok {
+		return nil, errors.New("field '" + name + "' is not defined")
+	}
+
+	if list, isList := value.([]interface{}); isList && !f.Multiple {
+		switch len(list) {
+		case 0:
+			value = nil
+		case 1:
+			value = list[0]
+		default:
+			return nil, errors.New("field '" + name + "' can't store more than one value")
+		}
+	}
+
+	var props []datastore.Property
+	if value != nil {
+		if _, isList := value.([]interface{}); !isList && f.Multiple {
+			value = []interface{}{value}
+		}
+		var err error
+		props, err = f.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []datastore.Property
+	var added bool
+	for _, p := range ps {
+		if !belongsTo(k, p.Name, name) {
+			out = append(out, p)
+		} else if !added {
+			out = append(out, props...)
+			added = true
+		}
+	}
+	if !added {
+		out = append(out, props...)
+	}
+	return out, nil
+}
+
+// Applies steps to stored entries of Kind in batches. Kind holds the new fields; entries are read
+// and written with Kind.Store in the namespace of the context. Meta properties aren't changed.
+type Migration struct {
+	Kind      *Kind
+	Steps     []Step
+	BatchSize int  // entries read per batch; 0 uses DefaultMigrationBatchSize
+	DryRun    bool // steps are applied but entries aren't stored
+}
+
+// Migration progress; Cursor continues the migration with the next batch
+type Progress struct {
+	Read    int               `json:"read"`
+	Changed int               `json:"changed"` // with DryRun entries that would change
+	Failed  []*MigrationError `json:"failed"`
+	Cursor  string            `json:"cursor,omitempty"`
+	Done    bool              `json:"done"`
+	DryRun  bool              `json:"dryRun"`
+}
+
+// Entry steps failed for; the entry is left as it is
+type MigrationError struct {
+	Id    string `json:"id"` // encoded entry key
+	Error string `json:"error"`
+}
+
+// Runs one batch starting at cursor; empty cursor starts at the first entry
+func (m *Migration) RunBatch(ctx context.Context, cursor string) (*Progress, error) {
+	var q = store.NewQuery(m.Kind.Name)
+	q.Limit = m.BatchSize
+	if q.Limit <= 0 {
+		q.Limit = DefaultMigrationBatchSize
+	}
+	q.Cursor = cursor
+
+	entities, next, err := m.Kind.Store.Run(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var p = &Progress{Read: len(entities), Failed: []*MigrationError{}, DryRun: m.DryRun}
+	for _, e := range entities {
+		changed, err := m.migrate(ctx, e)
+		if err != nil {
+			p.Failed = append(p.Failed, &MigrationError{Id: e.Key.Encode(), Error: err.Error()})
+		} else if changed {
+			p.Changed++
+		}
+	}
+
+	if len(entities) < q.Limit {
+		p.Done = true
+	} else {
+		p.Cursor = next
+	}
+	return p, nil
+}
+
+// Runs batches until all entries are migrated; progress is called after each batch with totals so far
+func (m *Migration) Run(ctx context.Context, progress func(p *Progress)) (*Progress, error) {
+	var total = &Progress{Failed: []*MigrationError{}, DryRun: m.DryRun}
+	for !total.Done {
+		p, err := m.RunBatch(ctx, total.Cursor)
+		if err != nil {
+			return total, err
+		}
+		total.Read += p.Read
+		total.Changed += p.Changed
+		total.Failed = append(total.Failed, p.Failed...)
+		total.Cursor = p.Cursor
+		total.Done = p.Done
+		if progress != nil {
+			progress(total)
+		}
+	}
+	return total, nil
+}
+
+// Applies steps to entry and stores it when changed; the entry is read again in a transaction
+// so concurrent updates aren't overwritten, and it is reported changed only when it was stored
+func (m *Migration) migrate(ctx context.Context, e *store.Entity) (bool, error) {
+	_, changed, err := m.apply(e.Properties)
+	if err != nil || !changed || m.DryRun {
+		return changed, err
+	}
+
+	err = m.Kind.Store.RunInTransaction(ctx, func(tc context.Context) error {
+		var stored datastore.PropertyList
+		if err := m.Kind.Store.Get(tc, e.Key, &stored); err != nil {
+			return err
+		}
+		var ps []datastore.Property
+		var err error
+		ps, changed, err = m.apply(stored)
+		if err != nil || !changed {
+			return err
+		}
+		var list = datastore.PropertyList(ps)
+		_, err = m.Kind.Store.Put(tc, e.Key, &list)
+		return err
+	})
+	return changed && err == nil, err
+}
+
+func (m *Migration) apply(ps []datastore.Property) ([]datastore.Property, bool, error) {
+	var out = append([]datastore.Property{}, ps...)
+	var err error
+	for _, s := range m.Steps {
+		out, err = s.Apply(m.Kind, out)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(out) != len(ps) {
+		return out, true, nil
+	}
+	for i := range out {
+		if !reflect.DeepEqual(out[i], ps[i]) {
+			return out, true, nil
+		}
+	}
+	return out, false, nil
+}
+
+// Serializable migration step, e.g. {"op": "rename", "from": ["name"], "to": ["title"]}
+type StepSpec struct {
+	Op        string      `json:"op"`                  // rename, split, merge, type or default
+	From      []string    `json:"from,omitempty"`      // rename and split take one field
+	To        []string    `json:"to,omitempty"`        // rename and merge take one field
+	Field     string      `json:"field,omitempty"`     // type and default
+	Separator string      `json:"separator,omitempty"` // split and merge string values
+	Type      string      `json:"type,omitempty"`      // string, int, float or bool; empty keeps values as they are
+	Value     interface{} `json:"value,omitempty"`     // default
+}
+
+func (s *StepSpec) Step() (Step, error) {
+	switch s.Op {
+	case "rename":
+		if len(s.From) != 1 || len(s.To) != 1 {
+			return nil, errors.New("rename takes one field in from and to")
+		}
+		return RenameField(s.From[0], s.To[0]), nil
+	case "split":
+		if len(s.From) != 1 || len(s.To) == 0 {
+			return nil, errors.New("split takes one field in from and fields in to")
+		}
+		var n = len(s.To)
+		return SplitField(s.From[0], s.To, func(value interface{}) ([]interface{}, error) {
+			var values = make([]interface{}, n)
+			if value == nil {
+				return values, nil
+			}
+			str, ok := value.(string)
+			if !ok {
+				return nil, errors.New("field '" + s.From[0] + "' value is not a string")
+			}
+			for i, part := range strings.SplitN(str, s.Separator, n) {
+				values[i] = part
+			}
+			return values, nil
+		}), nil
+	case "merge":
+		if len(s.From) == 0 || len(s.To) != 1 {
+			return nil, errors.New("merge takes fields in from and one field in to")
+		}
+		return MergeFields(s.From, s.To[0], func(values []interface{}) (interface{}, error) {
+			var parts []string
+			for _, v := range values {
+				if v == nil {
+					continue
+				}
+				str, err := convertValue("string", v)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, str.(string))
+			}
+			if len(parts) == 0 {
+				return nil, nil
+			}
+			return strings.Join(parts, s.Separator), nil
+		}), nil
+	case "type":
+		if len(s.Field) == 0 {
+			return nil, errors.New("type takes field")
+		}
+		if len(s.Type) == 0 {
+			return ChangeType(s.Field, nil), nil
+		}
+		if _, err := convertValue(s.Type, nil); err != nil {
+			return nil, err
+		}
+		return ChangeType(s.Field, func(value interface{}) (interface{}, error) {
+			if values, ok := value.([]interface{}); ok {
+				var out = make([]interface{}, len(values))
+				for i, v := range values {
+					var err error
+					if out[i], err = convertValue(s.Type, v); err != nil {
+						return nil, err
+					}
+				}
+				return out, nil
+			}
+			return convertValue(s.Type, value)
+		}), nil
+	case "default":
+		if len(s.Field) == 0 {
+			return nil, errors.New("default takes field")
+		}
+		return Backfill(s.Field, s.Value), nil
+	}
+	return nil, errors.New("migration step '" + s.Op + "' is not valid")
+}
+
+// Converts value to string, int (int64), float (float64) or bool; nil stays nil
+func convertValue(t string, value interface{}) (interface{}, error) {
+	switch t {
+	case "string", "int", "float", "bool":
+	default:
+		return nil, errors.New("type '" + t + "' is not valid")
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	var str string
+	var num float64
+	var isNum bool
+	switch v := value.(type) {
+	case string:
+		str = v
+	case int64:
+		num, isNum = float64(v), true
+		str = strconv.FormatInt(v, 10)
+	case float64:
+		num, isNum = v, true
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			num = 1
+		}
+		isNum = true
+		str = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("value type '%T' can't be converted", value)
+	}
+
+	switch t {
+	case "string":
+		return str, nil
+	case "bool":
+		if isNum {
+			return num != 0, nil
+		}
+		return strconv.ParseBool(str)
+	}
+	if !isNum {
+		var err error
+		if num, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+			return nil, errors.New("value '" + str + "' is not a number")
+		}
+	}
+	if t == "int" {
+		return int64(num), nil
+	}
+	return num, nil
+}
@@ -0,0 +1,113 @@
+package kind
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Creates worker of registered type; registered with RegisterWorker
+type WorkerFactory func() Worker
+
+var workers = struct {
+	sync.RWMutex
+	m map[string]WorkerFactory
+}{m: map[string]WorkerFactory{}}
+
+// Registers worker type under name used in Spec, e.g. kind.RegisterWorker("Text", func() kind.Worker { return &Text{} });
+// registering the same name again replaces the factory
+func RegisterWorker(name string, factory WorkerFactory) {
+	workers.Lock()
+	workers.m[name] = factory
+	workers.Unlock()
+}
+
+// Names of registered worker types
+func WorkerTypes() []string {
+	workers.RLock()
+	defer workers.RUnlock()
+	var names []string
+	for name := range workers.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates worker of the registered type; Kind.Init initializes it
+func NewWorker(name string) (Worker, error) {
+	workers.RLock()
+	factory, ok := workers.m[name]
+	workers.RUnlock()
+	if !ok {
+		return nil, errors.New("field type '" + name + "' is not registered")
+	}
+	return factory(), nil
+}
+
+// Serializable kind description, e.g. for kinds defined at runtime
+type Spec struct {
+	Name   string       `json:"name"`
+	Fields []*FieldSpec `json:"fields"`
+}
+
+type FieldSpec struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type,omitempty"` // registered worker type; empty for fields without worker
+	Required    bool     `json:"required,omitempty"`
+	Multiple    bool     `json:"multiple,omitempty"`
+	NoIndex     bool     `json:"noIndex,omitempty"`
+	ReadGroups  []string `json:"readGroups,omitempty"`
+	WriteGroups []string `json:"writeGroups,omitempty"`
+}
+
+// Creates kind from spec; unlike New it returns errors instead of panicking. Kind isn't initialized,
+// App shares sub kinds before calling Kind.Init, which returns field worker errors.
+func (s *Spec) New() (*Kind, error) {
+	var fields []*Field
+	for _, fs := range s.Fields {
+		if fs == nil {
+			return nil, errors.New("field can't be null")
+		}
+		fields = append(fields, &Field{
+			Name:        fs.Name,
+			IsRequired:  fs.Required,
+			Multiple:    fs.Multiple,
+			NoIndex:     fs.NoIndex,
+			ReadGroups:  fs.ReadGroups,
+			WriteGroups: fs.WriteGroups,
+		})
+	}
+	if err := check(s.Name, fields); err != nil {
+		return nil, err
+	}
+
+	for i, fs := range s.Fields {
+		if len(fs.Type) == 0 {
+			continue
+		}
+		w, err := NewWorker(fs.Type)
+		if err != nil {
+			return nil, errors.New("field '" + fs.Name + "': " + err.Error())
+		}
+		fields[i].Worker = w
+	}
+	return New(s.Name, fields), nil
+}
+
+// Describes kind as spec; fields with workers that aren't registered get their Go type name
+func (k *Kind) Spec() *Spec {
+	var s = &Spec{Name: k.Name, Fields: []*FieldSpec{}}
+	for _, f := range k.Fields {
+		s.Fields = append(s.Fields, &FieldSpec{
+			Name:        f.Name,
+			Type:        WorkerType(f.Worker),
+			Required:    f.IsRequired,
+			Multiple:    f.Multiple,
+			NoIndex:     f.NoIndex,
+			ReadGroups:  f.ReadGroups,
+			WriteGroups: f.WriteGroups,
+		})
+	}
+	return s
+}
@@ -3,30 +3,56 @@ package kind
 import (
 	"fmt"
 	"reflect"
-	"google.golang.org/appengine/datastore"
+
 	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
 )
 
+// Field type set as Field.Worker, e.g. &kind.Field{Name: "title", Worker: &field.Text{}}.
+// Methods get the field the worker is attached to, so name, required and multiple flags are only set on Field.
 type Worker interface {
-	Init() error
-	Parse(value interface{}) ([]datastore.Property, error)
-	Output(ctx context.Context, value interface{}) interface{}
+	// Called once by Kind.Init; returns error when the worker doesn't support the field, e.g. multiple values
+	Init(f *Field) error
+	Parse(f *Field, value interface{}) ([]datastore.Property, error)
+	Output(ctx context.Context, f *Field, value interface{}) interface{}
 }
 
+// Worker managing entries of another kind, e.g. categories field values refer to
+type SubKindWorker interface {
+	SubKind() *Kind
+}
 
+// Sub kind worker whose kind, when not set, is shared by all kinds of the App importing them.
+// App builds the shared kind with NewSubKind once and sets it on workers before Kind.Init.
+type SharedSubKindWorker interface {
+	SubKindWorker
+	NewSubKind() *Kind
+	SetSubKind(sub *Kind)
+}
+
+// Parses input value into properties with the field worker; fields without worker store values as they are
 func (x *Field) Parse(value interface{}) ([]datastore.Property, error) {
+	if x.Worker != nil {
+		return x.Worker.Parse(x, value)
+	}
+	return x.ParseValues(value, nil)
+}
+
+// Parses input value into field properties; multiple fields take an array of values. Each value
+// that isn't nil is checked and transformed by check; nil check stores values as they are.
+func (x *Field) ParseValues(value interface{}, check func(value interface{}) (interface{}, error)) ([]datastore.Property, error) {
 	var list []datastore.Property
 	if x.Multiple {
 		if multiArray, ok := value.([]interface{}); ok {
 			for _, value := range multiArray {
-				value, err := x.Check(value)
+				value, err := x.Check(value, check)
 				if err != nil {
 					return list, err
 				}
 				list = append(list, x.Property(value))
 			}
 		} else if value == nil {
-			value, err := x.Check(value)
+			value, err := x.Check(value, check)
 			if err != nil {
 				return list, err
 			}
@@ -35,7 +61,7 @@ func (x *Field) Parse(value interface{}) ([]datastore.Property, error) {
 			return list, fmt.Errorf("field '%s' value type '%s' is not valid", x.Name, reflect.TypeOf(value).String())
 		}
 	} else {
-		value, err := x.Check(value)
+		value, err := x.Check(value, check)
 		if err != nil {
 			return list, err
 		}
@@ -53,30 +79,24 @@ func (x *Field) Property(value interface{}) datastore.Property {
 	}
 }
 
-func (x *Field) Check(value interface{}) (interface{}, error) {
-	var err error
+// Checks required value; values that aren't nil are passed to check if set
+func (x *Field) Check(value interface{}, check func(value interface{}) (interface{}, error)) (interface{}, error) {
 	if value == nil {
 		if x.IsRequired {
 			return value, fmt.Errorf("field '%s' value is required", x.Name)
 		}
-	} else {
-		err = x.Validate(value)
-		if err != nil {
-			return value, err
-		}
-		value, err = x.Transform(value)
+		return value, nil
+	}
+	if check != nil {
+		return check(value)
 	}
-	return value, err
-}
-
-func (x *Field) Validate(value interface{}) error {
-	return nil
-}
-
-func (x *Field) Transform(value interface{}) (interface{}, error) {
 	return value, nil
 }
 
+// Returns stored value as output by the field worker; x can be nil for properties without field
 func (x *Field) Output(ctx context.Context, value interface{}) interface{} {
+	if x != nil && x.Worker != nil {
+		return x.Worker.Output(ctx, x, value)
+	}
 	return value
 }
@@ -1,17 +1,20 @@
 package middleware
 
-import jwt "github.com/dgrijalva/jwt-go"
+import (
+	"net/http"
 
-func AuthMiddleware(signingKey []byte) *JWTMiddleware {
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Signing method is checked by keyGetter as tokens can be signed with different algorithms
+func AuthMiddleware(keyGetter jwt.Keyfunc, tokenValidator func(r *http.Request, token *jwt.Token) error) *JWTMiddleware {
 	return New(MiddlewareOptions{
 		Extractor: FromFirst(
 			FromAuthHeader,
 			FromParameter("key"),
 		),
-		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			return signingKey, nil
-		},
-		SigningMethod:       jwt.SigningMethodHS256,
+		ValidationKeyGetter: keyGetter,
 		CredentialsOptional: true,
+		TokenValidator:      tokenValidator,
 	})
 }
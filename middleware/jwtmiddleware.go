@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,8 +9,6 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/context"
-	"google.golang.org/appengine"
-	clog "google.golang.org/appengine/log"
 )
 
 // A function called whenever an error is encountered
@@ -54,6 +53,9 @@ type MiddlewareOptions struct {
 	// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
 	// Default: nil
 	SigningMethod jwt.SigningMethod
+	// Called with a valid token; returning an error rejects the token, e.g. when its session was revoked
+	// Default: nil
+	TokenValidator func(r *http.Request, token *jwt.Token) error
 }
 
 type JWTMiddleware struct {
@@ -126,14 +128,12 @@ func (m *JWTMiddleware) Handler(h http.Handler) http.Handler {
 		// If there was an error, do not continue.
 		if err != nil {
 
-			ctx := appengine.NewContext(r)
-			clog.Debugf(ctx, "auth error: %s", err.Error())
+			m.logf("auth error: %s", err.Error())
 
 			if len(m.Options.RedirectOnError) > 0 {
 				redirect(w, r, m.Options.RedirectOnError)
 			} else {
-				panic(err)
-				//printError(w, err, http.StatusUnauthorized)
+				m.Options.ErrorHandler(w, r, err.Error())
 			}
 			return
 		}
@@ -236,7 +236,7 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) error {
 		errorMsg := "Required authorization token not found"
 		//m.Options.ErrorHandler(w, r, errorMsg)
 		m.logf("  Error: No credentials found (CredentialsOptional=false)")
-		return fmt.Errorf(errorMsg)
+		return errors.New(errorMsg)
 	}
 
 	// Now parse the token
@@ -267,6 +267,13 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("Token is invalid")
 	}
 
+	if m.Options.TokenValidator != nil {
+		if err := m.Options.TokenValidator(r, parsedToken); err != nil {
+			m.logf("Token rejected: %v", err)
+			return fmt.Errorf("Token is invalid: %v", err)
+		}
+	}
+
 	m.logf("JWT: %v", parsedToken)
 
 	// If we get here, everything worked and we can set the
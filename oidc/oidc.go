@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ales6164/go-cms/signing"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: id token is not valid")
+	ErrNoIDToken      = errors.New("oidc: token response has no id token")
+)
+
+// OpenID Connect provider using authorization code flow
+type Provider struct {
+	// Issuer URL; configuration is discovered from Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Page provider redirects back to with code and state query parameters
+	RedirectURL string
+	// Default: openid, email and profile
+	Scopes []string
+	// Default: http.DefaultClient
+	Client *http.Client
+
+	mu     sync.Mutex
+	config *Configuration
+	keys   map[string]*signing.Key
+}
+
+// Provider metadata served on the discovery endpoint
+type Configuration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity from verified id token
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+	Picture       string
+}
+
+func (p *Provider) get(ctx context.Context, u string, dst interface{}) error {
+	res, err := ctxhttp.Get(ctx, p.Client, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s responded with %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+// Discovers and caches provider configuration
+func (p *Provider) Configuration(ctx context.Context) (*Configuration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+
+	var config = new(Configuration)
+	err := p.get(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: issuer '%s' doesn't match configured issuer '%s'", config.Issuer, p.Issuer)
+	}
+	p.config = config
+	return config, nil
+}
+
+// URL user is redirected to for login
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string) (string, error) {
+	config, err := p.Configuration(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var scopes = p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	sep := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return config.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchanges authorization code for tokens and returns claims of the verified id token
+func (p *Provider) Exchange(ctx context.Context, code string, nonce string) (*Claims, error) {
+	config, err := p.Configuration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ctxhttp.PostForm(ctx, p.Client, config.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint responded with %s", res.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens.IDToken) == 0 {
+		return nil, ErrNoIDToken
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verifies id token signature, issuer, audience, expiration and nonce
+func (p *Provider) Verify(ctx context.Context, rawIDToken string, nonce string) (*Claims, error) {
+	config, err := p.Configuration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(rawIDToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, config, kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("expected %s signing method but token specified %s", key.Method.Alg(), token.Method.Alg())
+		}
+		return key.Verify, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if iss, _ := claims["iss"].(string); iss != config.Issuer {
+		return nil, ErrInvalidIDToken
+	}
+	if !hasAudience(claims["aud"], p.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	var c = new(Claims)
+	c.Subject, _ = claims["sub"].(string)
+	c.Email, _ = claims["email"].(string)
+	c.Name, _ = claims["name"].(string)
+	c.GivenName, _ = claims["given_name"].(string)
+	c.FamilyName, _ = claims["family_name"].(string)
+	c.Picture, _ = claims["picture"].(string)
+	// some providers send email_verified as string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+	if len(c.Subject) == 0 {
+		return nil, ErrInvalidIDToken
+	}
+	return c, nil
+}
+
+// Returns provider key by id; keys are fetched again when the id is not known, e.g. after provider key rotation
+func (p *Provider) key(ctx context.Context, config *Configuration, kid string) (*signing.Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	var set signing.JWKS
+	if err := p.get(ctx, config.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = map[string]*signing.Key{}
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.Key()
+		if err != nil {
+			continue
+		}
+		p.keys[key.Id] = key
+	}
+
+	if key := p.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key id '%s'", kid)
+}
+
+// token without kid can only be verified when provider has a single key
+func (p *Provider) lookup(kid string) *signing.Key {
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// aud claim is either a string or an array of strings
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+// Local OpenID Connect issuer for development and tests; every authorization request
+// is approved right away for Identity.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ales6164/go-cms/oidc"
+	"github.com/ales6164/go-cms/signing"
+	"github.com/dgrijalva/jwt-go"
+)
+
+type Issuer struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	// Identity id tokens are issued for
+	Identity oidc.Claims
+
+	keys  *signing.KeySet
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+type authRequest struct {
+	RedirectURI string
+	Nonce       string
+	Identity    oidc.Claims
+}
+
+// Starts issuer with a random ES256 key; call Close when done
+func NewIssuer(clientID string, clientSecret string) (*Issuer, error) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := signing.NewKeySet(&signing.Key{
+		Id:     "oidctest",
+		Method: jwt.SigningMethodES256,
+		Sign:   pk,
+		Verify: &pk.PublicKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Identity: oidc.Claims{
+			Subject:       "1",
+			Email:         "user@example.com",
+			EmailVerified: true,
+		},
+		keys:  keys,
+		codes: map[string]authRequest{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.configuration)
+	mux.HandleFunc("/authorize", i.authorize)
+	mux.HandleFunc("/token", i.token)
+	mux.HandleFunc("/jwks", i.jwks)
+	i.Server = httptest.NewServer(mux)
+	return i, nil
+}
+
+// Provider configured for the issuer
+func (i *Issuer) Provider(redirectURL string) *oidc.Provider {
+	return &oidc.Provider{
+		Issuer:       i.URL,
+		ClientID:     i.ClientID,
+		ClientSecret: i.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// Performs login like a browser would: opens loginURL, follows redirects to the issuer and returns
+// the URL issuer redirected back to, including code and state
+func (i *Issuer) Login(loginURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if via[len(via)-1].URL.Host == i.Listener.Addr().String() && req.URL.Host != i.Listener.Addr().String() {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+	res, err := client.Get(loginURL)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res.Location()
+}
+
+func (i *Issuer) configuration(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.Configuration{
+		Issuer:                i.URL,
+		AuthorizationEndpoint: i.URL + "/authorize",
+		TokenEndpoint:         i.URL + "/token",
+		JWKSURI:               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	i.mu.Lock()
+	i.codes[code] = authRequest{RedirectURI: redirectURI.String(), Nonce: q.Get("nonce"), Identity: i.Identity}
+	i.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_id") != i.ClientID || r.PostFormValue("client_secret") != i.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	i.mu.Lock()
+	req, ok := i.codes[r.PostFormValue("code")]
+	delete(i.codes, r.PostFormValue("code"))
+	i.mu.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != req.RedirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	var now = time.Now()
+	idToken, err := i.keys.SignToken(jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss":            i.URL,
+		"aud":            i.ClientID,
+		"sub":            req.Identity.Subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          req.Nonce,
+		"email":          req.Identity.Email,
+		"email_verified": req.Identity.EmailVerified,
+		"name":           req.Identity.Name,
+		"given_name":     req.Identity.GivenName,
+		"family_name":    req.Identity.FamilyName,
+		"picture":        req.Identity.Picture,
+	}))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, i.keys.JWKS())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ales6164/go-cms/instance"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/project"
+	"github.com/ales6164/go-cms/signing"
+	"github.com/ales6164/go-cms/user"
+	"github.com/gorilla/mux"
+)
+
+const OpenAPIVersion = "3.0.3"
+
+// Documentation of a route; request and response are values whose types describe the bodies,
+// requests are the input types handlers read
+type operation struct {
+	summary  string
+	request  interface{}
+	response interface{} // nil for responses without body
+	status   int         // success status; default 200, or 204 without response
+	query    []string    // query parameters
+}
+
+// auth routes keyed by "METHOD path"; kind routes are described by kindOperations
+var operations = map[string]operation{
+	"GET /api":                                       {summary: "Describe imported kinds", response: []*kind.Definition{}},
+	"GET /api/{kind}":                                {summary: "JSON Schema of kind entry input", response: map[string]interface{}{}},
+	"GET /openapi.json":                              {summary: "This document", response: map[string]interface{}{}},
+	"GET /.well-known/jwks.json":                     {summary: "Public keys verifying access tokens", response: signing.JWKS{}},
+	"POST /auth/login":                               {summary: "Log in with email and password; users with two-factor authentication get a TwoFactorChallenge", request: loginInput{}, response: instance.AuthResult{}},
+	"POST /auth/register":                            {summary: "Register user", request: registrationInput{}, response: instance.AuthResult{}},
+	"GET /auth/oidc/{provider}":                      {summary: "Redirect to identity provider login", status: http.StatusFound},
+	"GET /auth/oidc/{provider}/callback":             {summary: "Complete identity provider login", response: instance.AuthResult{}, query: []string{"code", "state"}},
+	"POST /auth/verify-email":                        {summary: "Verify email with token from verification email", request: tokenInput{}, response: user.User{}},
+	"POST /auth/verify-email/send":                   {summary: "Send verification email", request: emailInput{}},
+	"POST /auth/reset-password":                      {summary: "Set new password with token from reset email", request: resetPasswordInput{}},
+	"POST /auth/reset-password/send":                 {summary: "Send password reset email", request: emailInput{}},
+	"POST /auth/login/2fa":                           {summary: "Complete login with TOTP or recovery code", request: twoFactorLoginInput{}, response: instance.AuthResult{}},
+	"POST /auth/2fa/enroll":                          {summary: "Create TOTP secret", response: TwoFactorEnrollment{}},
+	"POST /auth/2fa/confirm":                         {summary: "Enable two-factor authentication", request: codeInput{}, response: RecoveryCodes{}},
+	"POST /auth/2fa/disable":                         {summary: "Disable two-factor authentication", request: codeInput{}},
+	"POST /auth/refresh":                             {summary: "Issue new access token", request: refreshInput{}, response: instance.Token{}},
+	"POST /auth/logout":                              {summary: "Revoke current session or all sessions", query: []string{"all"}},
+	"GET /auth/me":                                   {summary: "Authenticated user", response: user.User{}},
+	"PATCH /auth/me":                                 {summary: "Update profile", request: profileInput{}, response: user.User{}},
+	"PUT /auth/me/password":                          {summary: "Change password and revoke other sessions", request: passwordInput{}},
+	"GET /auth/users":                                {summary: "List users", response: UserList{}, query: []string{"limit", "cursor"}},
+	"GET /auth/users/{email}":                        {summary: "Get user", response: user.User{}},
+	"PUT /auth/users/{email}/disabled":               {summary: "Disable or enable user", request: userDisabledInput{}, response: user.User{}},
+	"PUT /auth/users/{email}/groups":                 {summary: "Set user groups", request: userGroupsInput{}, response: user.User{}},
+	"DELETE /auth/users/{email}/sessions":            {summary: "Revoke all sessions of user"},
+	"GET /auth/apikeys":                              {summary: "List API keys", response: []*APIKey{}},
+	"POST /auth/apikeys":                             {summary: "Create API key", request: apiKeyInput{}, response: APIKeyResult{}, status: http.StatusCreated},
+	"DELETE /auth/apikeys/{id}":                      {summary: "Revoke API key"},
+	"POST /auth/project":                             {summary: "Switch active project", request: switchProjectInput{}, response: instance.Token{}},
+	"GET /projects":                                  {summary: "List projects of the authenticated user", response: []*project.Project{}},
+	"POST /projects":                                 {summary: "Create project", request: projectInput{}, response: project.Project{}, status: http.StatusCreated},
+	"GET /projects/{project}":                        {summary: "Get project", response: project.Project{}},
+	"GET /projects/{project}/members":                {summary: "List project members", response: []*project.Member{}},
+	"PUT /projects/{project}/members/{email}":        {summary: "Change member role", request: roleInput{}, response: project.Member{}},
+	"DELETE /projects/{project}/members/{email}":     {summary: "Remove member or leave project"},
+	"PUT /projects/{project}/owner":                  {summary: "Transfer ownership", request: emailRoleInput{}, response: []*project.Member{}},
+	"GET /projects/{project}/invitations":            {summary: "List pending invitations", response: []*project.Invitation{}},
+	"POST /projects/{project}/invitations":           {summary: "Invite user", request: emailRoleInput{}, response: project.Invitation{}, status: http.StatusCreated},
+	"DELETE /projects/{project}/invitations/{email}": {summary: "Cancel invitation"},
+	"GET /auth/invitations":                          {summary: "List invitations of the authenticated user", response: []*project.Invitation{}},
+	"POST /auth/invitations/{project}":               {summary: "Accept invitation", response: project.Member{}},
+	"DELETE /auth/invitations/{project}":             {summary: "Decline invitation"},
+	"GET /kinds":                                     {summary: "List kinds defined at runtime", response: []*kind.Spec{}},
+	"POST /kinds":                                    {summary: "Define kind", request: kind.Spec{}, response: kind.Spec{}, status: http.StatusCreated},
+	"PUT /kinds/{kind}":                              {summary: "Replace fields of kind defined at runtime", request: kind.Spec{}, response: kind.Spec{}},
+	"DELETE /kinds/{kind}":                           {summary: "Delete kind defined at runtime; entries are kept"},
+	"POST /kinds/{kind}/migrations":                  {summary: "Run batch of migration steps over stored entries", request: migrationInput{}, response: kind.Progress{}},
+}
+
+// Documents kind routes; responses refer to kind schemas added by OpenAPI
+func kindOperations(k *kind.Kind) map[string]operation {
+	path := "/" + strings.ToLower(k.Name)
+	entry := schemaRef("kind." + k.Name + ".entry")
+	input := schemaRef("kind." + k.Name)
+	return map[string]operation{
+		"GET " + path:              {summary: "List " + k.Name + " entries", response: schemaRef("kind." + k.Name + ".list"), query: []string{"filter", "order", "limit", "cursor"}},
+		"POST " + path:             {summary: "Add " + k.Name + " entry", request: input, response: entry, status: http.StatusCreated},
+		"GET " + path + "/{id}":    {summary: "Get " + k.Name + " entry", response: entry},
+		"PUT " + path + "/{id}":    {summary: "Replace " + k.Name + " entry", request: input, response: entry},
+		"PATCH " + path + "/{id}":  {summary: "Update " + k.Name + " entry; missing fields are kept", request: input, response: entry},
+		"DELETE " + path + "/{id}": {summary: "Delete " + k.Name + " entry"},
+	}
+}
+
+// Reference to a schema in document components
+type schemaRef string
+
+var pathParamPattern = regexp.MustCompile(`{([^}:]+)[^}]*}`)
+
+// OpenAPI 3 document of routes Serve registers under rootPath with JSON Schemas of imported kinds
+// and kinds defined at runtime that are currently loaded; kind fields user groups can't read are left out
+func (a *App) OpenAPI(rootPath string, groups []string) map[string]interface{} {
+	var g = &schemaGenerator{schemas: map[string]interface{}{}}
+	var kinds = a.loadedKinds()
+
+	for _, k := range kinds {
+		g.schemas["kind."+k.Name] = k.ObjectSchema(groups)
+		g.schemas["kind."+k.Name+".entry"] = map[string]interface{}{
+			"allOf": []interface{}{
+				map[string]interface{}{"$ref": "#/components/schemas/kind." + k.Name},
+				map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id": map[string]interface{}{"type": "string"},
+						"meta": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"createdAt": map[string]interface{}{"type": "string", "format": "date-time"},
+								"updatedAt": map[string]interface{}{"type": "string", "format": "date-time"},
+								"createdBy": map[string]interface{}{"type": "string"},
+								"updatedBy": map[string]interface{}{"type": "string"},
+								"status":    map[string]interface{}{"type": "string"},
+								"version":   map[string]interface{}{"type": "integer"},
+							},
+						},
+					},
+				},
+			},
+		}
+		g.schemas["kind."+k.Name+".list"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"entries": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/kind." + k.Name + ".entry"}},
+				"cursor":  map[string]interface{}{"type": "string"},
+			},
+		}
+	}
+
+	prefix := strings.TrimRight(rootPath, "/")
+	var paths = map[string]interface{}{}
+	var addOperation = func(method, path string, op operation) {
+		item, ok := paths[prefix+path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[prefix+path] = item
+		}
+		item[strings.ToLower(method)] = g.operation(path, op)
+	}
+
+	a.router(rootPath).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := strings.TrimPrefix(tpl, prefix)
+		// kind routes are documented per kind
+		if path == "/{kind}" || strings.HasPrefix(path, "/{kind}/") {
+			return nil
+		}
+		for _, method := range methods {
+			addOperation(method, path, operations[method+" "+path])
+		}
+		return nil
+	})
+	for _, k := range kinds {
+		for key, op := range kindOperations(k) {
+			method := strings.SplitN(key, " ", 2)
+			addOperation(method[0], method[1], op)
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "go-cms",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		// routes work anonymously with the public group's permissions
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+	}
+}
+
+// Serves OpenAPI document as seen by the caller
+func (a *App) OpenAPIHandler(rootPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		ctx, err := a.projectContext(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		if err := a.loadRuntimeKinds(ctx, false); err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		ctx.PrintResult(w, a.OpenAPI(rootPath, callerGroups(ctx)))
+	}
+}
+
+// Serves JSON Schema of kind entry input for {kind} route variable as seen by the caller
+func (a *App) KindSchemaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, ctx := instance.NewContext(r).Authenticate()
+		ctx, err := a.projectContext(ctx)
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		k, err := a.findKind(ctx, strings.ToLower(mux.Vars(r)["kind"]))
+		if err != nil {
+			ctx.PrintError(w, err)
+			return
+		}
+		ctx.PrintResult(w, k.JSONSchema(callerGroups(ctx)))
+	}
+}
+
+// Builds JSON Schemas from Go types; named struct types are added to schemas and referenced
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+func (g *schemaGenerator) operation(path string, op operation) map[string]interface{} {
+	var o = map[string]interface{}{}
+	if len(op.summary) > 0 {
+		o["summary"] = op.summary
+	}
+
+	var params []interface{}
+	for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, name := range op.query {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "query",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+
+	if op.request != nil {
+		o["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(op.request)},
+			},
+		}
+	}
+
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+		if op.response == nil {
+			status = http.StatusNoContent
+		}
+	}
+	var success = map[string]interface{}{"description": http.StatusText(status)}
+	if op.response != nil {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": g.schema(op.response)},
+		}
+	}
+	o["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "Error message",
+			"content": map[string]interface{}{
+				"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+		},
+	}
+	return o
+}
+
+func (g *schemaGenerator) schema(v interface{}) map[string]interface{} {
+	if ref, ok := v.(schemaRef); ok {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + string(ref)}
+	}
+	return g.typeSchema(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		if len(t.Name()) == 0 {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = map[string]interface{}{} // placeholder for recursive types
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// Properties are named by json tags; fields without omitempty are required
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	var props = map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		props[name] = g.typeSchema(f.Type)
+		if !strings.Contains(f.Tag.Get("json"), ",omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	var schema = map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
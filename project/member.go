@@ -0,0 +1,223 @@
+package project
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	ErrAlreadyMember = errors.New("project: user is already a project member")
+	ErrOwnerRole     = errors.New("project: owner role is changed only by transferring ownership")
+)
+
+// Invitation to join a project, stored as kind "ProjectInvitation" with invitee email as key name and project key as parent
+type Invitation struct {
+	Project   string    `datastore:"-" json:"project"`
+	Email     string    `datastore:"email" json:"email"`
+	Role      string    `datastore:"role,noindex" json:"role"`
+	InvitedBy string    `datastore:"invitedBy,noindex" json:"invitedBy"`
+	CreatedAt time.Time `datastore:"createdAt,noindex" json:"createdAt"`
+}
+
+func InvitationKey(ctx context.Context, namespace string, email string) *datastore.Key {
+	projectKey := Key(ctx, namespace)
+	rootCtx, _ := appengine.Namespace(ctx, "")
+	return datastore.NewKey(rootCtx, "ProjectInvitation", email, 0, projectKey)
+}
+
+// Invites user with email to the project; inviting again replaces the role of a pending invitation
+func Invite(ctx context.Context, s store.Store, namespace string, email string, role string, invitedBy string) (*Invitation, error) {
+	if role == RoleOwner {
+		return nil, ErrOwnerRole
+	}
+
+	var inv = &Invitation{
+		Project:   namespace,
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		CreatedAt: time.Now(),
+	}
+	err := s.RunInTransaction(ctx, func(tc context.Context) error {
+		err := s.Get(tc, MemberKey(tc, namespace, email), new(Member))
+		if err == nil {
+			return ErrAlreadyMember
+		}
+		if err != store.ErrNoSuchEntity {
+			return err
+		}
+		_, err = s.Put(tc, InvitationKey(tc, namespace, email), inv)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Pending invitations of the project
+func GetInvitations(ctx context.Context, s store.Store, namespace string) ([]*Invitation, error) {
+	rootCtx, err := appengine.Namespace(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	q := store.NewQuery("ProjectInvitation")
+	q.Ancestor = Key(rootCtx, namespace)
+	return runInvitations(rootCtx, s, q)
+}
+
+// Pending invitations of the user with email
+func GetUserInvitations(ctx context.Context, s store.Store, email string) ([]*Invitation, error) {
+	rootCtx, err := appengine.Namespace(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return runInvitations(rootCtx, s, store.NewQuery("ProjectInvitation").Filter("email", "=", email))
+}
+
+func runInvitations(ctx context.Context, s store.Store, q *store.Query) ([]*Invitation, error) {
+	entities, _, err := s.Run(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var invitations = []*Invitation{}
+	for _, e := range entities {
+		var inv = new(Invitation)
+		if err := datastore.LoadStruct(inv, e.Properties); err != nil {
+			return nil, err
+		}
+		inv.Project = e.Key.Parent().StringID()
+		invitations = append(invitations, inv)
+	}
+	return invitations, nil
+}
+
+// Makes invitee a member with the invited role; store.ErrNoSuchEntity if there is no invitation
+func Accept(ctx context.Context, s store.Store, namespace string, email string) (*Member, error) {
+	var m *Member
+	err := s.RunInTransaction(ctx, func(tc context.Context) error {
+		invKey := InvitationKey(tc, namespace, email)
+		var inv = new(Invitation)
+		if err := s.Get(tc, invKey, inv); err != nil {
+			return err
+		}
+		m = &Member{User: email, Role: inv.Role, CreatedAt: time.Now()}
+		if _, err := s.Put(tc, MemberKey(tc, namespace, email), m); err != nil {
+			return err
+		}
+		return s.Delete(tc, invKey)
+	})
+	return m, err
+}
+
+// Deletes invitation; used both to decline and to cancel it
+func DeleteInvitation(ctx context.Context, s store.Store, namespace string, email string) error {
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		invKey := InvitationKey(tc, namespace, email)
+		if err := s.Get(tc, invKey, new(Invitation)); err != nil {
+			return err
+		}
+		return s.Delete(tc, invKey)
+	})
+}
+
+// Members of the project
+func GetMembers(ctx context.Context, s store.Store, namespace string) ([]*Member, error) {
+	rootCtx, err := appengine.Namespace(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	q := store.NewQuery("ProjectMember")
+	q.Ancestor = Key(rootCtx, namespace)
+	entities, _, err := s.Run(rootCtx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var members = []*Member{}
+	for _, e := range entities {
+		var m = new(Member)
+		if err := datastore.LoadStruct(m, e.Properties); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// Changes role of a member; owner role can't be given or taken this way
+func SetRole(ctx context.Context, s store.Store, namespace string, email string, role string) (*Member, error) {
+	if role == RoleOwner {
+		return nil, ErrOwnerRole
+	}
+	var m = new(Member)
+	err := s.RunInTransaction(ctx, func(tc context.Context) error {
+		key := MemberKey(tc, namespace, email)
+		if err := s.Get(tc, key, m); err != nil {
+			if err == store.ErrNoSuchEntity {
+				return ErrNotMember
+			}
+			return err
+		}
+		if m.Role == RoleOwner {
+			return ErrOwnerRole
+		}
+		m.Role = role
+		_, err := s.Put(tc, key, m)
+		return err
+	})
+	return m, err
+}
+
+// Removes member from the project; owner can't be removed
+func RemoveMember(ctx context.Context, s store.Store, namespace string, email string) error {
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		key := MemberKey(tc, namespace, email)
+		var m = new(Member)
+		if err := s.Get(tc, key, m); err != nil {
+			if err == store.ErrNoSuchEntity {
+				return ErrNotMember
+			}
+			return err
+		}
+		if m.Role == RoleOwner {
+			return ErrOwnerRole
+		}
+		return s.Delete(tc, key)
+	})
+}
+
+// Makes member with email the owner; the previous owner stays a member with role
+func TransferOwnership(ctx context.Context, s store.Store, namespace string, from string, to string, role string) error {
+	if role == RoleOwner {
+		return ErrOwnerRole
+	}
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		fromKey, toKey := MemberKey(tc, namespace, from), MemberKey(tc, namespace, to)
+		var prev, next = new(Member), new(Member)
+		if err := s.Get(tc, fromKey, prev); err != nil {
+			if err == store.ErrNoSuchEntity {
+				return ErrNotMember
+			}
+			return err
+		}
+		if prev.Role != RoleOwner {
+			return ErrOwnerRole
+		}
+		if err := s.Get(tc, toKey, next); err != nil {
+			if err == store.ErrNoSuchEntity {
+				return ErrNotMember
+			}
+			return err
+		}
+		prev.Role, next.Role = role, RoleOwner
+		_, err := s.PutMulti(tc, []*datastore.Key{fromKey, toKey}, []interface{}{prev, next})
+		return err
+	})
+}
@@ -0,0 +1,152 @@
+package project
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Role of the user who created the project
+const RoleOwner = "owner"
+
+var (
+	ErrInvalidNamespace = errors.New("project: namespace must be 3 to 63 lowercase letters, digits or dashes")
+	ErrAlreadyExists    = errors.New("project: project already exists")
+	ErrNotMember        = errors.New("project: user is not a project member")
+)
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+
+// Tenant; kind entries of a project are stored in the datastore namespace named after it.
+// Projects and their members are stored in the default namespace.
+type Project struct {
+	Namespace string    `datastore:"-" json:"namespace"`
+	Name      string    `datastore:"name,noindex" json:"name"`
+	CreatedBy string    `datastore:"createdBy" json:"createdBy"`
+	CreatedAt time.Time `datastore:"createdAt" json:"createdAt"`
+	Role      string    `datastore:"-" json:"role,omitempty"` // role of the user projects were listed for
+}
+
+// Project membership, stored as kind "ProjectMember" with user email as key name and project key as parent
+type Member struct {
+	User      string    `datastore:"user" json:"user"`
+	Role      string    `datastore:"role" json:"role"`
+	CreatedAt time.Time `datastore:"createdAt,noindex" json:"createdAt"`
+}
+
+func ValidNamespace(namespace string) bool {
+	return namespacePattern.MatchString(namespace)
+}
+
+// Returns context kind entries of the project are read and written in
+func Context(ctx context.Context, namespace string) (context.Context, error) {
+	return appengine.Namespace(ctx, namespace)
+}
+
+// keys are always created in the default namespace
+func Key(ctx context.Context, namespace string) *datastore.Key {
+	rootCtx, _ := appengine.Namespace(ctx, "")
+	return datastore.NewKey(rootCtx, "Project", namespace, 0, nil)
+}
+
+func MemberKey(ctx context.Context, namespace string, email string) *datastore.Key {
+	projectKey := Key(ctx, namespace)
+	rootCtx, _ := appengine.Namespace(ctx, "")
+	return datastore.NewKey(rootCtx, "ProjectMember", email, 0, projectKey)
+}
+
+// Creates project with owner as its first member
+func Create(ctx context.Context, s store.Store, namespace string, name string, owner string) (*Project, error) {
+	if !ValidNamespace(namespace) {
+		return nil, ErrInvalidNamespace
+	}
+
+	var now = time.Now()
+	var p = &Project{
+		Namespace: namespace,
+		Name:      name,
+		CreatedBy: owner,
+		CreatedAt: now,
+		Role:      RoleOwner,
+	}
+
+	err := s.RunInTransaction(ctx, func(tc context.Context) error {
+		key := Key(tc, namespace)
+		err := s.Get(tc, key, &datastore.PropertyList{})
+		if err == nil {
+			return ErrAlreadyExists
+		}
+		if err != store.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err = s.PutMulti(tc,
+			[]*datastore.Key{key, MemberKey(tc, namespace, owner)},
+			[]interface{}{p, &Member{User: owner, Role: RoleOwner, CreatedAt: now}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func Get(ctx context.Context, s store.Store, namespace string) (*Project, error) {
+	var p = new(Project)
+	err := s.Get(ctx, Key(ctx, namespace), p)
+	if err != nil {
+		return nil, err
+	}
+	p.Namespace = namespace
+	return p, nil
+}
+
+// Returns membership of the user; ErrNotMember if there is none
+func GetMember(ctx context.Context, s store.Store, namespace string, email string) (*Member, error) {
+	var m = new(Member)
+	err := s.Get(ctx, MemberKey(ctx, namespace, email), m)
+	if err == store.ErrNoSuchEntity {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns projects the user is a member of with Role set to the user's role
+func GetUserProjects(ctx context.Context, s store.Store, userKey *datastore.Key) ([]*Project, error) {
+	var projects = []*Project{}
+
+	rootCtx, err := appengine.Namespace(ctx, "")
+	if err != nil {
+		return projects, err
+	}
+	entities, _, err := s.Run(rootCtx, store.NewQuery("ProjectMember").Filter("user", "=", userKey.StringID()))
+	if err != nil {
+		return projects, err
+	}
+
+	for _, e := range entities {
+		var m = new(Member)
+		if err := datastore.LoadStruct(m, e.Properties); err != nil {
+			return projects, err
+		}
+		p, err := Get(rootCtx, s, e.Key.Parent().StringID())
+		if err != nil {
+			if err == store.ErrNoSuchEntity {
+				continue
+			}
+			return projects, err
+		}
+		p.Role = m.Role
+		projects = append(projects, p)
+	}
+
+	return projects, nil
+}
@@ -0,0 +1,113 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrInvalidPEM     = errors.New("signing: no PEM data found")
+	ErrUnsupportedKey = errors.New("signing: unsupported key type")
+	ErrNoPrivateKey   = errors.New("signing: key can only verify tokens")
+	ErrNoSigningKey   = errors.New("signing: no signing key configured")
+)
+
+// Key signing and verifying tokens. Id is written to token "kid" header.
+type Key struct {
+	Id     string
+	Method jwt.SigningMethod
+	Sign   interface{} // []byte, *rsa.PrivateKey or *ecdsa.PrivateKey; nil for keys only verifying tokens
+	Verify interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// HS256 key with shared secret
+func NewHMAC(id string, secret []byte) *Key {
+	return &Key{
+		Id:     id,
+		Method: jwt.SigningMethodHS256,
+		Sign:   secret,
+		Verify: secret,
+	}
+}
+
+// HS256 key with random secret; tokens signed with it stop working on restart
+func GenerateHMAC(id string) (*Key, error) {
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewHMAC(id, secret), nil
+}
+
+// Parses PEM encoded RSA (RS256) or ECDSA (ES256, ES384, ES512 by curve) key.
+// Private keys (PKCS1, PKCS8, SEC1) sign and verify, public keys (PKIX) only verify.
+func ParsePEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	var parsed interface{}
+	var err error
+	if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				if parsed, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+					return nil, ErrUnsupportedKey
+				}
+			}
+		}
+	}
+
+	var k = &Key{Id: id}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.Method, k.Sign, k.Verify = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Method, k.Verify = jwt.SigningMethodRS256, key
+	case *ecdsa.PrivateKey:
+		k.Sign, k.Verify = key, &key.PublicKey
+		k.Method, err = ecdsaMethod(key.Curve)
+	case *ecdsa.PublicKey:
+		k.Verify = key
+		k.Method, err = ecdsaMethod(key.Curve)
+	default:
+		err = ErrUnsupportedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// Sets token algorithm and kid header and signs it
+func (k *Key) SignToken(token *jwt.Token) (string, error) {
+	if k.Sign == nil {
+		return "", ErrNoPrivateKey
+	}
+	token.Method = k.Method
+	token.Header["alg"] = k.Method.Alg()
+	if len(k.Id) > 0 {
+		token.Header["kid"] = k.Id
+	}
+	return token.SignedString(k.Sign)
+}
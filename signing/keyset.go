@@ -0,0 +1,169 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Signing key with keys accepted when verifying tokens, e.g. keys signing tokens before rotation.
+// Tokens without kid header are verified with the signing key.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+func NewKeySet(signing *Key, verification ...*Key) (*KeySet, error) {
+	if signing == nil {
+		return nil, ErrNoSigningKey
+	}
+	if signing.Sign == nil {
+		return nil, ErrNoPrivateKey
+	}
+	s := &KeySet{signing: signing, keys: map[string]*Key{}}
+	for _, k := range append([]*Key{signing}, verification...) {
+		if _, ok := s.keys[k.Id]; ok {
+			return nil, errors.New("signing: duplicate key id '" + k.Id + "'")
+		}
+		s.keys[k.Id] = k
+	}
+	return s, nil
+}
+
+func (s *KeySet) SigningKey() *Key {
+	return s.signing
+}
+
+func (s *KeySet) SignToken(token *jwt.Token) (string, error) {
+	return s.signing.SignToken(token)
+}
+
+// jwt.Keyfunc returning verification key by token kid; token algorithm must match the key
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	var k = s.signing
+	if kid, ok := token.Header["kid"].(string); ok {
+		if k, ok = s.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id '%s'", kid)
+		}
+	}
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("expected %s signing method but token specified %s", k.Method.Alg(), token.Method.Alg())
+	}
+	return k.Verify, nil
+}
+
+// JSON Web Key Set as served to other services verifying tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Public keys of the set; HMAC secrets are never included
+func (s *KeySet) JWKS() JWKS {
+	var set = JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		var jwk = JWK{Kid: k.Id, Alg: k.Method.Alg(), Use: "sig"}
+		switch key := k.Verify.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeInt(key.N, 0)
+			jwk.E = encodeInt(big.NewInt(int64(key.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = key.Curve.Params().Name
+			jwk.X = encodeInt(key.X, size)
+			jwk.Y = encodeInt(key.Y, size)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// Parses public key, e.g. one fetched from identity provider's jwks_uri; the key only verifies tokens
+func (j JWK) Key() (*Key, error) {
+	var k = &Key{Id: j.Kid}
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		k.Verify = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		k.Method = jwt.SigningMethodRS256
+		if len(j.Alg) > 0 {
+			method, ok := jwt.GetSigningMethod(j.Alg).(*jwt.SigningMethodRSA)
+			if !ok {
+				return nil, ErrUnsupportedKey
+			}
+			k.Method = method
+		}
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := decodeInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		k.Verify = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if k.Method, err = ecdsaMethod(curve); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	return k, nil
+}
+
+// base64url big-endian bytes, left padded to size
+func encodeInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, ErrUnsupportedKey
+	}
+	return new(big.Int).SetBytes(b), nil
+}
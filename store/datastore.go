@@ -0,0 +1,82 @@
+package store
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// App Engine datastore backed Store
+type Datastore struct{}
+
+func NewDatastore() *Datastore {
+	return &Datastore{}
+}
+
+func (s *Datastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	return datastore.Get(ctx, key, dst)
+}
+
+func (s *Datastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	return datastore.Put(ctx, key, src)
+}
+
+func (s *Datastore) PutMulti(ctx context.Context, keys []*datastore.Key, src []interface{}) ([]*datastore.Key, error) {
+	return datastore.PutMulti(ctx, keys, src)
+}
+
+func (s *Datastore) Delete(ctx context.Context, key *datastore.Key) error {
+	return datastore.Delete(ctx, key)
+}
+
+func (s *Datastore) Run(ctx context.Context, q *Query) ([]*Entity, string, error) {
+	var entities []*Entity
+
+	dq := datastore.NewQuery(q.Kind)
+	if q.Ancestor != nil {
+		dq = dq.Ancestor(q.Ancestor)
+	}
+	for _, f := range q.Filters {
+		dq = dq.Filter(f.Name+" "+f.Operator, f.Value)
+	}
+	for _, o := range q.Orders {
+		if o.Descending {
+			dq = dq.Order("-" + o.Name)
+		} else {
+			dq = dq.Order(o.Name)
+		}
+	}
+	if q.Limit > 0 {
+		dq = dq.Limit(q.Limit)
+	}
+	if len(q.Cursor) > 0 {
+		cursor, err := datastore.DecodeCursor(q.Cursor)
+		if err != nil {
+			return entities, "", err
+		}
+		dq = dq.Start(cursor)
+	}
+
+	t := dq.Run(ctx)
+	for {
+		var ps datastore.PropertyList
+		key, err := t.Next(&ps)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return entities, "", err
+		}
+		entities = append(entities, &Entity{Key: key, Properties: ps})
+	}
+
+	cursor, err := t.Cursor()
+	if err != nil {
+		return entities, "", err
+	}
+
+	return entities, cursor.String(), nil
+}
+
+func (s *Datastore) RunInTransaction(ctx context.Context, f func(tc context.Context) error) error {
+	return datastore.RunInTransaction(ctx, f, &datastore.TransactionOptions{XG: true})
+}
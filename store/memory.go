@@ -0,0 +1,199 @@
+package store
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var ErrNestedTransaction = errors.New("store: nested transactions are not supported")
+
+// In-memory Store for tests and local development; data is lost when the process exits.
+//
+// Transactions run one at a time and their writes are applied only after f returns without an error.
+// Query cursors are offsets, so they can skip or repeat entries if data changes between pages.
+type Memory struct {
+	mu       sync.RWMutex
+	txMu     sync.Mutex
+	lastId   int64
+	entities map[string]*Entity
+}
+
+type memoryTx struct {
+	puts    map[string]*Entity
+	deletes map[string]bool
+}
+
+type memoryTxKey struct{}
+
+// Outside of App Engine set GAE_APPLICATION, e.g. "dev~local", so datastore.NewKey doesn't read
+// the app id from the metadata server.
+func NewMemory() *Memory {
+	return &Memory{
+		entities: map[string]*Entity{},
+	}
+}
+
+func memoryKey(key *datastore.Key) string {
+	return key.Namespace() + "|" + keyPath(key)
+}
+
+func transaction(ctx context.Context) *memoryTx {
+	tx, _ := ctx.Value(memoryTxKey{}).(*memoryTx)
+	return tx
+}
+
+func (s *Memory) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	id := memoryKey(key)
+
+	if tx := transaction(ctx); tx != nil {
+		if tx.deletes[id] {
+			return ErrNoSuchEntity
+		}
+		if e, ok := tx.puts[id]; ok {
+			return load(dst, copyProperties(e.Properties))
+		}
+	}
+
+	s.mu.RLock()
+	e, ok := s.entities[id]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrNoSuchEntity
+	}
+
+	return load(dst, copyProperties(e.Properties))
+}
+
+func (s *Memory) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	keys, err := s.PutMulti(ctx, []*datastore.Key{key}, []interface{}{src})
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (s *Memory) PutMulti(ctx context.Context, keys []*datastore.Key, src []interface{}) ([]*datastore.Key, error) {
+	if len(keys) != len(src) {
+		return nil, errors.New("store: key and src slices have different length")
+	}
+
+	var entities = make([]*Entity, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			return nil, datastore.ErrInvalidKey
+		}
+		ps, err := save(src[i])
+		if err != nil {
+			return nil, err
+		}
+		if key.Incomplete() {
+			key, err = s.completeKey(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entities[i] = &Entity{Key: key, Properties: copyProperties(ps)}
+	}
+
+	if tx := transaction(ctx); tx != nil {
+		for _, e := range entities {
+			id := memoryKey(e.Key)
+			delete(tx.deletes, id)
+			tx.puts[id] = e
+		}
+	} else {
+		s.mu.Lock()
+		for _, e := range entities {
+			s.entities[memoryKey(e.Key)] = e
+		}
+		s.mu.Unlock()
+	}
+
+	var out = make([]*datastore.Key, len(entities))
+	for i, e := range entities {
+		out[i] = e.Key
+	}
+	return out, nil
+}
+
+// allocates a new integer id keeping the key namespace
+func (s *Memory) completeKey(ctx context.Context, key *datastore.Key) (*datastore.Key, error) {
+	s.mu.Lock()
+	s.lastId++
+	id := s.lastId
+	s.mu.Unlock()
+
+	nsCtx, err := appengine.Namespace(ctx, key.Namespace())
+	if err != nil {
+		return nil, err
+	}
+	return datastore.NewKey(nsCtx, key.Kind(), "", id, key.Parent()), nil
+}
+
+func (s *Memory) Delete(ctx context.Context, key *datastore.Key) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	id := memoryKey(key)
+
+	if tx := transaction(ctx); tx != nil {
+		delete(tx.puts, id)
+		tx.deletes[id] = true
+		return nil
+	}
+
+	s.mu.Lock()
+	delete(s.entities, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Memory) Run(ctx context.Context, q *Query) ([]*Entity, string, error) {
+	namespace := contextNamespace(ctx)
+
+	s.mu.RLock()
+	var entities []*Entity
+	for _, e := range s.entities {
+		if e.Key.Namespace() == namespace {
+			entities = append(entities, &Entity{Key: e.Key, Properties: copyProperties(e.Properties)})
+		}
+	}
+	s.mu.RUnlock()
+
+	return q.page(q.apply(entities))
+}
+
+func (s *Memory) RunInTransaction(ctx context.Context, f func(tc context.Context) error) error {
+	if transaction(ctx) != nil {
+		return ErrNestedTransaction
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	tx := &memoryTx{
+		puts:    map[string]*Entity{},
+		deletes: map[string]bool{},
+	}
+	if err := f(context.WithValue(ctx, memoryTxKey{}, tx)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for id := range tx.deletes {
+		delete(s.entities, id)
+	}
+	for id, e := range tx.puts {
+		s.entities[id] = e
+	}
+	s.mu.Unlock()
+
+	return nil
+}
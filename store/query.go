@@ -0,0 +1,262 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Helpers for stores that evaluate queries in memory
+
+var ErrInvalidCursor = errors.New("store: invalid cursor")
+
+// namespace the query is run in; same as datastore takes it from context
+func contextNamespace(ctx context.Context) string {
+	return datastore.NewIncompleteKey(ctx, "_", nil).Namespace()
+}
+
+func hasAncestor(key *datastore.Key, ancestor *datastore.Key) bool {
+	for k := key; k != nil; k = k.Parent() {
+		if k.Equal(ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// reports if entity matches query kind, ancestor and filters; unindexed properties never match a filter
+func (q *Query) match(e *Entity) bool {
+	if e.Key.Kind() != q.Kind {
+		return false
+	}
+	if q.Ancestor != nil && !hasAncestor(e.Key, q.Ancestor) {
+		return false
+	}
+	for _, f := range q.Filters {
+		var matched bool
+		for _, p := range e.Properties {
+			if p.Name != f.Name || p.NoIndex {
+				continue
+			}
+			if c, ok := compare(p.Value, f.Value); ok && satisfies(c, f.Operator) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func satisfies(c int, operator string) bool {
+	switch operator {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// value used for ordering; as with datastore the smallest value of a multiple property is used
+// for ascending and the largest for descending order
+func orderValue(e *Entity, o Order) (interface{}, bool) {
+	var value interface{}
+	var found bool
+	for _, p := range e.Properties {
+		if p.Name != o.Name || p.NoIndex {
+			continue
+		}
+		if !found {
+			value, found = p.Value, true
+			continue
+		}
+		if c, ok := compare(p.Value, value); ok && ((c < 0 && !o.Descending) || (c > 0 && o.Descending)) {
+			value = p.Value
+		}
+	}
+	return value, found
+}
+
+// filters and sorts entities; entities without ordered properties are left out as datastore does
+func (q *Query) apply(entities []*Entity) []*Entity {
+	var out []*Entity
+	var values = map[*Entity][]interface{}{}
+
+Entities:
+	for _, e := range entities {
+		if !q.match(e) {
+			continue
+		}
+		for _, o := range q.Orders {
+			v, ok := orderValue(e, o)
+			if !ok {
+				continue Entities
+			}
+			values[e] = append(values[e], v)
+		}
+		out = append(out, e)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		for n, o := range q.Orders {
+			c, _ := compare(values[out[i]][n], values[out[j]][n])
+			if c == 0 {
+				continue
+			}
+			if o.Descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return compareKeys(out[i].Key, out[j].Key) < 0
+	})
+
+	return out
+}
+
+// returns page of entities starting at query cursor; cursors are offsets into the ordered result
+func (q *Query) page(entities []*Entity) ([]*Entity, string, error) {
+	offset, err := q.offset()
+	if err != nil {
+		return nil, "", err
+	}
+	if offset > len(entities) {
+		offset = len(entities)
+	}
+
+	end := len(entities)
+	if q.Limit > 0 && offset+q.Limit < end {
+		end = offset + q.Limit
+	}
+
+	return entities[offset:end], offsetCursor(end), nil
+}
+
+// offset query cursor points to
+func (q *Query) offset() (int, error) {
+	if len(q.Cursor) == 0 {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+func offsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func compareKeys(a, b *datastore.Key) int {
+	return strings.Compare(keyPath(a), keyPath(b))
+}
+
+var keyPathEscaper = strings.NewReplacer("%", "%25", "/", "%2F", ",", "%2C")
+
+// Path of key from its root, e.g. /post,i0000000000000000005/version,sv1. Unlike Key.String string ids
+// are told apart from int ids and escaped, so paths of different keys differ, the path of an ancestor
+// followed by "/" is a prefix of paths of its descendants, and paths order int ids by value.
+func keyPath(key *datastore.Key) string {
+	var b strings.Builder
+	writeKeyPath(&b, key)
+	return b.String()
+}
+
+func writeKeyPath(b *strings.Builder, key *datastore.Key) {
+	if key.Parent() != nil {
+		writeKeyPath(b, key.Parent())
+	}
+	b.WriteString("/" + keyPathEscaper.Replace(key.Kind()) + ",")
+	if len(key.StringID()) > 0 {
+		b.WriteString("s" + keyPathEscaper.Replace(key.StringID()))
+	} else {
+		b.WriteString(fmt.Sprintf("i%019d", key.IntID()))
+	}
+}
+
+// compares two property values; ok is false when values are not comparable
+func compare(a, b interface{}) (c int, ok bool) {
+	switch x := a.(type) {
+	case nil:
+		return 0, b == nil
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareFloat(float64(x), float64(y)), true
+		case float64:
+			return compareFloat(float64(x), y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareFloat(x, float64(y)), true
+		case float64:
+			return compareFloat(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			} else if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			if x.Before(y) {
+				return -1, true
+			} else if x.After(y) {
+				return 1, true
+			}
+			return 0, true
+		}
+	case *datastore.Key:
+		if y, ok := b.(*datastore.Key); ok {
+			if x == nil || y == nil {
+				return 0, x == nil && y == nil
+			}
+			return compareKeys(x, y), true
+		}
+	}
+	return 0, false
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,593 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	Postgres
+)
+
+// Relational Store. Each entity is a row in cms_entities with its properties stored in a JSON column
+// as a typed property list, so nested (a.b) names, multiple values and meta.* properties are kept as they are.
+// Old versions written by Holder.Update are rows with the entry as their parent key.
+//
+// Indexed property values are also rows in cms_properties, so queries select entities by namespace, kind,
+// ancestor and equality filters in SQL. Range filters and ordering are evaluated in Go the same as in the
+// Memory store over the selected rows; queries with equality filters only are also paged in SQL.
+//
+// Rows are identified by namespace and key path, which tells string ids from int ids.
+//
+// Entities read by Get inside a transaction are locked until it ends: on Postgres with SELECT ... FOR UPDATE,
+// on SQLite the database is locked by its first write, so of transactions that read the same entity before
+// either writes it one fails with a busy error instead of overwriting the other's write. Open SQLite
+// with immediate transactions, e.g. "_txlock=immediate" with modernc.org/sqlite, to have them wait instead.
+//
+// Register a database/sql driver for the dialect before opening db.
+type SQL struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+type sqlTxKey struct{}
+
+// cms_sequences row holding the version of stored rows; kind names can't contain "_"
+const schemaSequence = "cms_schema"
+
+// Version 1 added cms_properties, version 2 changed ids from Key.String to keyPath
+const schemaVersion = 2
+
+// queries run either on db or inside a transaction
+type sqlRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Creates tables if they don't exist. Outside of App Engine set GAE_APPLICATION, e.g. "dev~myapp",
+// so datastore.NewKey doesn't read the app id from the metadata server.
+func NewSQL(db *sql.DB, dialect Dialect) (*SQL, error) {
+	s := &SQL{db: db, dialect: dialect}
+	for _, q := range []string{
+		`CREATE TABLE IF NOT EXISTS cms_entities (
+			namespace TEXT NOT NULL,
+			id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			key TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (namespace, id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS cms_entities_kind ON cms_entities (namespace, kind)`,
+		`CREATE TABLE IF NOT EXISTS cms_sequences (
+			name TEXT NOT NULL PRIMARY KEY,
+			value BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS cms_properties (
+			namespace TEXT NOT NULL,
+			id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			value TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS cms_properties_value ON cms_properties (namespace, name, type, value)`,
+		`CREATE INDEX IF NOT EXISTS cms_properties_entity ON cms_properties (namespace, id)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.upgrade(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rewrites ids and index rows of entities stored by earlier versions; runs once per database
+func (s *SQL) upgrade(ctx context.Context) error {
+	return s.RunInTransaction(ctx, func(tc context.Context) error {
+		r := s.runner(tc)
+		_, err := r.ExecContext(tc, s.rebind(`INSERT INTO cms_sequences (name, value) VALUES (?, 0) ON CONFLICT (name) DO NOTHING`), schemaSequence)
+		if err != nil {
+			return err
+		}
+		var version int64
+		if err := r.QueryRowContext(tc, s.rebind(`SELECT value FROM cms_sequences WHERE name = ?`), schemaSequence).Scan(&version); err != nil {
+			return err
+		}
+		if version >= schemaVersion {
+			return nil
+		}
+
+		// rows are read first as a connection can't run statements while reading rows
+		type row struct{ namespace, id, key, data string }
+		var stored []row
+		rows, err := r.QueryContext(tc, `SELECT namespace, id, key, data FROM cms_entities`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var e row
+			if err := rows.Scan(&e.namespace, &e.id, &e.key, &e.data); err != nil {
+				rows.Close()
+				return err
+			}
+			stored = append(stored, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if _, err := r.ExecContext(tc, `DELETE FROM cms_properties`); err != nil {
+			return err
+		}
+		for _, e := range stored {
+			key, err := datastore.DecodeKey(e.key)
+			if err != nil {
+				return err
+			}
+			id := keyPath(key)
+			_, err = r.ExecContext(tc, s.rebind(`UPDATE cms_entities SET id = ? WHERE namespace = ? AND id = ?`), id, e.namespace, e.id)
+			if err != nil {
+				return err
+			}
+			ps, err := decodeProperties(e.data)
+			if err != nil {
+				return err
+			}
+			if err := s.index(tc, e.namespace, id, ps); err != nil {
+				return err
+			}
+		}
+		_, err = r.ExecContext(tc, s.rebind(`UPDATE cms_sequences SET value = ? WHERE name = ?`), schemaVersion, schemaSequence)
+		return err
+	})
+}
+
+// replaces ? placeholders with $n for postgres
+func (s *SQL) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+	var b strings.Builder
+	var n int
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (s *SQL) runner(ctx context.Context) sqlRunner {
+	if tx, ok := ctx.Value(sqlTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+func (s *SQL) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+
+	// entities read in a transaction are locked until it ends
+	query := `SELECT data FROM cms_entities WHERE namespace = ? AND id = ?`
+	if _, ok := ctx.Value(sqlTxKey{}).(*sql.Tx); ok && s.dialect == Postgres {
+		query += ` FOR UPDATE`
+	}
+
+	var data string
+	err := s.runner(ctx).QueryRowContext(ctx, s.rebind(query), key.Namespace(), keyPath(key)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return ErrNoSuchEntity
+	} else if err != nil {
+		return err
+	}
+
+	ps, err := decodeProperties(data)
+	if err != nil {
+		return err
+	}
+	return load(dst, ps)
+}
+
+func (s *SQL) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	keys, err := s.PutMulti(ctx, []*datastore.Key{key}, []interface{}{src})
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (s *SQL) PutMulti(ctx context.Context, keys []*datastore.Key, src []interface{}) ([]*datastore.Key, error) {
+	if len(keys) != len(src) {
+		return nil, fmt.Errorf("store: key and src slices have different length")
+	}
+
+	// entity rows and their index rows are written together
+	if _, ok := ctx.Value(sqlTxKey{}).(*sql.Tx); !ok {
+		var out []*datastore.Key
+		err := s.RunInTransaction(ctx, func(tc context.Context) error {
+			var err error
+			out, err = s.PutMulti(tc, keys, src)
+			return err
+		})
+		return out, err
+	}
+
+	var out = make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			return nil, datastore.ErrInvalidKey
+		}
+		ps, err := save(src[i])
+		if err != nil {
+			return nil, err
+		}
+		if key.Incomplete() {
+			key, err = s.completeKey(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		data, err := encodeProperties(ps)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.runner(ctx).ExecContext(ctx, s.rebind(`INSERT INTO cms_entities (namespace, id, kind, key, data) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (namespace, id) DO UPDATE SET data = excluded.data`),
+			key.Namespace(), keyPath(key), key.Kind(), key.Encode(), data)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.index(ctx, key.Namespace(), keyPath(key), ps); err != nil {
+			return nil, err
+		}
+		out[i] = key
+	}
+	return out, nil
+}
+
+// allocates a new integer id from the kind sequence keeping the key namespace
+func (s *SQL) completeKey(ctx context.Context, key *datastore.Key) (*datastore.Key, error) {
+	var id int64
+	var allocate = func(r sqlRunner) error {
+		_, err := r.ExecContext(ctx, s.rebind(`INSERT INTO cms_sequences (name, value) VALUES (?, 0) ON CONFLICT (name) DO NOTHING`), key.Kind())
+		if err != nil {
+			return err
+		}
+		_, err = r.ExecContext(ctx, s.rebind(`UPDATE cms_sequences SET value = value + 1 WHERE name = ?`), key.Kind())
+		if err != nil {
+			return err
+		}
+		return r.QueryRowContext(ctx, s.rebind(`SELECT value FROM cms_sequences WHERE name = ?`), key.Kind()).Scan(&id)
+	}
+
+	var err error
+	if tx, ok := ctx.Value(sqlTxKey{}).(*sql.Tx); ok {
+		err = allocate(tx)
+	} else {
+		var tx *sql.Tx
+		tx, err = s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err = allocate(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nsCtx, err := appengine.Namespace(ctx, key.Namespace())
+	if err != nil {
+		return nil, err
+	}
+	return datastore.NewKey(nsCtx, key.Kind(), "", id, key.Parent()), nil
+}
+
+func (s *SQL) Delete(ctx context.Context, key *datastore.Key) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	if _, ok := ctx.Value(sqlTxKey{}).(*sql.Tx); !ok {
+		return s.RunInTransaction(ctx, func(tc context.Context) error {
+			return s.Delete(tc, key)
+		})
+	}
+	_, err := s.runner(ctx).ExecContext(ctx, s.rebind(`DELETE FROM cms_entities WHERE namespace = ? AND id = ?`),
+		key.Namespace(), keyPath(key))
+	if err != nil {
+		return err
+	}
+	return s.index(ctx, key.Namespace(), keyPath(key), nil)
+}
+
+// Replaces index rows of entity with its indexed property values
+func (s *SQL) index(ctx context.Context, namespace, id string, ps []datastore.Property) error {
+	r := s.runner(ctx)
+	_, err := r.ExecContext(ctx, s.rebind(`DELETE FROM cms_properties WHERE namespace = ? AND id = ?`), namespace, id)
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		if p.NoIndex {
+			continue
+		}
+		t, v, ok := indexValue(p.Value)
+		if !ok {
+			continue
+		}
+		_, err := r.ExecContext(ctx, s.rebind(`INSERT INTO cms_properties (namespace, id, name, type, value) VALUES (?, ?, ?, ?, ?)`),
+			namespace, id, p.Name, t, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Type and value of property in cms_properties; values equal when compared in Go have the same text.
+// Values Go can't compare, e.g. geo points, aren't indexed as they never match a filter.
+func indexValue(value interface{}) (string, string, bool) {
+	switch x := value.(type) {
+	case nil:
+		return "null", "", true
+	case string:
+		return "string", x, true
+	case int64:
+		return "number", numberValue(float64(x)), true
+	case float64:
+		return "number", numberValue(x), true
+	case bool:
+		return "bool", strconv.FormatBool(x), true
+	case []byte:
+		return "bytes", base64.StdEncoding.EncodeToString(x), true
+	case time.Time:
+		return "time", x.UTC().Format(time.RFC3339Nano), true
+	case *datastore.Key:
+		if x == nil {
+			return "null", "", true
+		}
+		return "key", keyPath(x), true
+	}
+	return "", "", false
+}
+
+// numbers are compared as float64, so int64 and float64 values that are equal share the text
+func numberValue(f float64) string {
+	if f == 0 {
+		f = 0 // -0
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (s *SQL) Run(ctx context.Context, q *Query) ([]*Entity, string, error) {
+	var where = []string{"namespace = ?", "kind = ?"}
+	var args = []interface{}{contextNamespace(ctx), q.Kind}
+	if q.Ancestor != nil {
+		prefix := keyPath(q.Ancestor) + "/"
+		where = append(where, "(id = ? OR substr(id, 1, ?) = ?)")
+		args = append(args, keyPath(q.Ancestor), utf8.RuneCountInString(prefix), prefix)
+	}
+	var paged = q.Ancestor == nil && len(q.Orders) == 0
+	for _, f := range q.Filters {
+		t, v, ok := indexValue(f.Value)
+		if f.Operator != "=" || !ok {
+			paged = false
+			continue
+		}
+		where = append(where, `EXISTS (SELECT 1 FROM cms_properties p WHERE p.namespace = cms_entities.namespace
+			AND p.id = cms_entities.id AND p.name = ? AND p.type = ? AND p.value = ?)`)
+		args = append(args, f.Name, t, v)
+	}
+	query := `SELECT key, data FROM cms_entities WHERE ` + strings.Join(where, " AND ")
+
+	// SQL selects exactly the query result, which is ordered by key the same as in Go
+	var offset int
+	if paged {
+		var err error
+		if offset, err = q.offset(); err != nil {
+			return nil, "", err
+		}
+		query += ` ORDER BY id`
+		if s.dialect == Postgres {
+			query += ` COLLATE "C"`
+		}
+		if q.Limit > 0 {
+			query += ` LIMIT ?`
+			args = append(args, q.Limit)
+		} else if s.dialect == SQLite {
+			query += ` LIMIT -1`
+		}
+		query += ` OFFSET ?`
+		args = append(args, offset)
+	}
+
+	rows, err := s.runner(ctx).QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var entities []*Entity
+	for rows.Next() {
+		var encodedKey, data string
+		if err := rows.Scan(&encodedKey, &data); err != nil {
+			return nil, "", err
+		}
+		key, err := datastore.DecodeKey(encodedKey)
+		if err != nil {
+			return nil, "", err
+		}
+		ps, err := decodeProperties(data)
+		if err != nil {
+			return nil, "", err
+		}
+		entities = append(entities, &Entity{Key: key, Properties: ps})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if paged {
+		return q.apply(entities), offsetCursor(offset + len(entities)), nil
+	}
+	return q.page(q.apply(entities))
+}
+
+func (s *SQL) RunInTransaction(ctx context.Context, f func(tc context.Context) error) error {
+	if _, ok := ctx.Value(sqlTxKey{}).(*sql.Tx); ok {
+		return ErrNestedTransaction
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(context.WithValue(ctx, sqlTxKey{}, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// property as stored in the data JSON column
+type sqlProperty struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value"`
+	Multiple bool            `json:"multiple,omitempty"`
+	NoIndex  bool            `json:"noIndex,omitempty"`
+}
+
+func encodeProperties(ps []datastore.Property) (string, error) {
+	var out = make([]sqlProperty, len(ps))
+	for i, p := range ps {
+		var t string
+		var v interface{}
+		switch x := p.Value.(type) {
+		case nil:
+			t = "null"
+		case string:
+			t, v = "string", x
+		case int64:
+			t, v = "int", x
+		case float64:
+			t, v = "float", x
+		case bool:
+			t, v = "bool", x
+		case []byte:
+			t, v = "bytes", x
+		case datastore.ByteString:
+			t, v = "bytestring", []byte(x)
+		case time.Time:
+			t, v = "time", x.UTC().Format(time.RFC3339Nano)
+		case *datastore.Key:
+			if x == nil {
+				t = "null"
+			} else {
+				t, v = "key", x.Encode()
+			}
+		case appengine.GeoPoint:
+			t, v = "geo", x
+		case appengine.BlobKey:
+			t, v = "blobkey", string(x)
+		default:
+			return "", fmt.Errorf("store: property '%s' value type %T is not supported", p.Name, p.Value)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		out[i] = sqlProperty{Name: p.Name, Type: t, Value: b, Multiple: p.Multiple, NoIndex: p.NoIndex}
+	}
+	b, err := json.Marshal(out)
+	return string(b), err
+}
+
+func decodeProperties(data string) ([]datastore.Property, error) {
+	var in []sqlProperty
+	if err := json.Unmarshal([]byte(data), &in); err != nil {
+		return nil, err
+	}
+
+	var ps = make([]datastore.Property, len(in))
+	for i, sp := range in {
+		var err error
+		var v interface{}
+		switch sp.Type {
+		case "null":
+		case "string":
+			var x string
+			err = json.Unmarshal(sp.Value, &x)
+			v = x
+		case "int":
+			var x int64
+			err = json.Unmarshal(sp.Value, &x)
+			v = x
+		case "float":
+			var x float64
+			err = json.Unmarshal(sp.Value, &x)
+			v = x
+		case "bool":
+			var x bool
+			err = json.Unmarshal(sp.Value, &x)
+			v = x
+		case "bytes":
+			var x []byte
+			err = json.Unmarshal(sp.Value, &x)
+			v = x
+		case "bytestring":
+			var x []byte
+			err = json.Unmarshal(sp.Value, &x)
+			v = datastore.ByteString(x)
+		case "time":
+			var x string
+			if err = json.Unmarshal(sp.Value, &x); err == nil {
+				v, err = time.Parse(time.RFC3339Nano, x)
+			}
+		case "key":
+			var x string
+			if err = json.Unmarshal(sp.Value, &x); err == nil {
+				v, err = datastore.DecodeKey(x)
+			}
+		case "geo":
+			var x appengine.GeoPoint
+			err = json.Unmarshal(sp.Value, &x)
+			v = x
+		case "blobkey":
+			var x string
+			err = json.Unmarshal(sp.Value, &x)
+			v = appengine.BlobKey(x)
+		default:
+			err = fmt.Errorf("store: property '%s' type '%s' is not supported", sp.Name, sp.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = datastore.Property{Name: sp.Name, Value: v, Multiple: sp.Multiple, NoIndex: sp.NoIndex}
+	}
+	return ps, nil
+}
@@ -0,0 +1,49 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+)
+
+// Rows stored with Key.String ids and without index rows are found after NewSQL upgrades them
+func TestSQLUpgrade(t *testing.T) {
+	var ctx = context.Background()
+	db := openSQLite(t)
+	s, err := store.NewSQL(db, store.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := putPost(t, s, ctx, nil, &post{Title: "a"})
+
+	for _, q := range []string{
+		`UPDATE cms_entities SET id = '` + key.String() + `'`,
+		`DELETE FROM cms_properties`,
+		`UPDATE cms_sequences SET value = 0 WHERE name = 'cms_schema'`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = store.NewSQL(db, store.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p post
+	if err := s.Get(ctx, key, &p); err != nil || p.Title != "a" {
+		t.Fatalf("got %v %v", p, err)
+	}
+	entities, _, err := s.Run(ctx, store.NewQuery("post").Filter("Title", "=", "a"))
+	if err != nil || len(entities) != 1 {
+		t.Errorf("got %d entities, %v", len(entities), err)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get(ctx, key, &p); err != store.ErrNoSuchEntity {
+		t.Errorf("got %v after delete", err)
+	}
+}
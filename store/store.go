@@ -0,0 +1,92 @@
+package store
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Returned by Get when there is no entity stored under the key; same as datastore.ErrNoSuchEntity
+var ErrNoSuchEntity = datastore.ErrNoSuchEntity
+
+// Store persists kind entries and users. Keys and properties are plain datastore values,
+// only reading and writing goes through the Store so it can be backed by something other than App Engine.
+//
+// Values passed as dst and src are datastore.PropertyLoadSaver (as kind.Holder) or pointers to structs
+// the same as with the datastore package.
+type Store interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	PutMulti(ctx context.Context, keys []*datastore.Key, src []interface{}) ([]*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+	// Runs query and returns matching entities with a cursor pointing after the last one
+	Run(ctx context.Context, q *Query) ([]*Entity, string, error)
+	// Runs f in a transaction; all calls inside f must use the transaction context tc
+	RunInTransaction(ctx context.Context, f func(tc context.Context) error) error
+}
+
+// Entity as returned by query
+type Entity struct {
+	Key        *datastore.Key
+	Properties []datastore.Property
+}
+
+type Filter struct {
+	Name     string
+	Operator string // one of =, <, <=, >, >=
+	Value    interface{}
+}
+
+type Order struct {
+	Name       string
+	Descending bool
+}
+
+type Query struct {
+	Kind     string
+	Ancestor *datastore.Key
+	Filters  []Filter
+	Orders   []Order
+	Limit    int
+	Cursor   string
+}
+
+func NewQuery(kind string) *Query {
+	return &Query{Kind: kind}
+}
+
+func (q *Query) Filter(name string, operator string, value interface{}) *Query {
+	q.Filters = append(q.Filters, Filter{Name: name, Operator: operator, Value: value})
+	return q
+}
+
+func (q *Query) Order(name string, descending bool) *Query {
+	q.Orders = append(q.Orders, Order{Name: name, Descending: descending})
+	return q
+}
+
+// returns properties of a datastore.PropertyLoadSaver or a struct pointer
+func save(src interface{}) ([]datastore.Property, error) {
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		return pls.Save()
+	}
+	return datastore.SaveStruct(src)
+}
+
+// loads properties into a datastore.PropertyLoadSaver or a struct pointer
+func load(dst interface{}, ps []datastore.Property) error {
+	if pls, ok := dst.(datastore.PropertyLoadSaver); ok {
+		return pls.Load(ps)
+	}
+	return datastore.LoadStruct(dst, ps)
+}
+
+func copyProperties(ps []datastore.Property) []datastore.Property {
+	var out = make([]datastore.Property, len(ps))
+	for i, p := range ps {
+		if b, ok := p.Value.([]byte); ok {
+			p.Value = append([]byte(nil), b...)
+		}
+		out[i] = p
+	}
+	return out
+}
@@ -0,0 +1,393 @@
+package store_test
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ales6164/go-cms/field"
+	"github.com/ales6164/go-cms/kind"
+	"github.com/ales6164/go-cms/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	_ "modernc.org/sqlite"
+)
+
+type post struct {
+	Title string
+	Tags  []string
+	Score int
+	Body  string `datastore:",noindex"`
+}
+
+// datastore.NewKey reads the app id from GAE_APPLICATION outside of App Engine
+func TestMain(m *testing.M) {
+	if len(os.Getenv("GAE_APPLICATION")) == 0 {
+		os.Setenv("GAE_APPLICATION", "dev~local")
+	}
+	os.Exit(m.Run())
+}
+
+// Runs test against Memory and against SQL on SQLite
+func forEachStore(t *testing.T, test func(t *testing.T, s store.Store)) {
+	t.Run("Memory", func(t *testing.T) {
+		test(t, store.NewMemory())
+	})
+	t.Run("SQLite", func(t *testing.T) {
+		test(t, newSQLite(t))
+	})
+}
+
+func newSQLite(t *testing.T) *store.SQL {
+	s, err := store.NewSQL(openSQLite(t), store.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "cms.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func putPost(t *testing.T, s store.Store, ctx context.Context, parent *datastore.Key, p *post) *datastore.Key {
+	key, err := s.Put(ctx, datastore.NewIncompleteKey(ctx, "post", parent), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestGetPutDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+
+		key := putPost(t, s, ctx, nil, &post{Title: "a"})
+		if key.Incomplete() {
+			t.Fatal("key wasn't completed")
+		}
+
+		var p post
+		if err := s.Get(ctx, key, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Title != "a" {
+			t.Errorf("got title %q", p.Title)
+		}
+
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Get(ctx, key, &p); err != store.ErrNoSuchEntity {
+			t.Errorf("got %v after delete", err)
+		}
+	})
+}
+
+func TestParents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+
+		parent := putPost(t, s, ctx, nil, &post{Title: "parent"})
+		child := putPost(t, s, ctx, parent, &post{Title: "child"})
+		putPost(t, s, ctx, nil, &post{Title: "other"})
+
+		if !child.Parent().Equal(parent) {
+			t.Fatalf("child parent is %v", child.Parent())
+		}
+
+		var p post
+		if err := s.Get(ctx, child, &p); err != nil || p.Title != "child" {
+			t.Fatalf("got %v %v", p, err)
+		}
+
+		// ancestor queries include the ancestor itself
+		entities, _, err := s.Run(ctx, &store.Query{Kind: "post", Ancestor: parent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entities) != 2 {
+			t.Fatalf("got %d entities", len(entities))
+		}
+	})
+}
+
+func TestMultipleProperties(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+
+		key := putPost(t, s, ctx, nil, &post{Title: "a", Tags: []string{"x", "y"}})
+		putPost(t, s, ctx, nil, &post{Title: "b", Tags: []string{"z"}})
+
+		var p post
+		if err := s.Get(ctx, key, &p); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p.Tags, []string{"x", "y"}) {
+			t.Errorf("got tags %v", p.Tags)
+		}
+
+		// filters match any of the values
+		entities, _, err := s.Run(ctx, store.NewQuery("post").Filter("Tags", "=", "y"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entities) != 1 || !entities[0].Key.Equal(key) {
+			t.Errorf("got %d entities", len(entities))
+		}
+	})
+}
+
+// string and int ids with the same text are different keys, also when used as ancestors and in filters
+func TestKeyIds(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+		stringKey := datastore.NewKey(ctx, "post", "5", 0, nil)
+		intKey := datastore.NewKey(ctx, "post", "", 5, nil)
+		slashKey := datastore.NewKey(ctx, "post", "5/post,6", 0, nil)
+		for _, key := range []*datastore.Key{stringKey, intKey, slashKey} {
+			if _, err := s.Put(ctx, key, &post{Title: key.StringID()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		child := putPost(t, s, ctx, stringKey, &post{Title: "child"})
+
+		var p post
+		if err := s.Get(ctx, intKey, &p); err != nil || p.Title != "" {
+			t.Errorf("got %v %v for int id", p, err)
+		}
+
+		entities, _, err := s.Run(ctx, &store.Query{Kind: "post", Ancestor: stringKey})
+		if err != nil || len(entities) != 2 {
+			t.Errorf("got %d entities of string id ancestor, %v", len(entities), err)
+		}
+		entities, _, err = s.Run(ctx, &store.Query{Kind: "post", Ancestor: intKey})
+		if err != nil || len(entities) != 1 {
+			t.Errorf("got %d entities of int id ancestor, %v", len(entities), err)
+		}
+
+		if _, err := s.Put(ctx, datastore.NewIncompleteKey(ctx, "comment", nil), &datastore.PropertyList{
+			{Name: "post", Value: child},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		entities, _, err = s.Run(ctx, store.NewQuery("comment").Filter("post", "=", child))
+		if err != nil || len(entities) != 1 {
+			t.Errorf("got %d entities for key filter, %v", len(entities), err)
+		}
+		otherChild := datastore.NewKey(ctx, "post", "", child.IntID(), intKey)
+		entities, _, err = s.Run(ctx, store.NewQuery("comment").Filter("post", "=", otherChild))
+		if err != nil || len(entities) != 0 {
+			t.Errorf("got %d entities for filter by key of int id parent, %v", len(entities), err)
+		}
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+		key := putPost(t, s, ctx, nil, &post{Title: "a"})
+
+		var errAbort = errors.New("abort")
+		err := s.RunInTransaction(ctx, func(tc context.Context) error {
+			if _, err := s.Put(tc, key, &post{Title: "b"}); err != nil {
+				return err
+			}
+			var p post
+			if err := s.Get(tc, key, &p); err != nil || p.Title != "b" {
+				t.Errorf("transaction doesn't read its own write: %v %v", p, err)
+			}
+			return errAbort
+		})
+		if err != errAbort {
+			t.Fatal(err)
+		}
+
+		var p post
+		if err := s.Get(ctx, key, &p); err != nil || p.Title != "a" {
+			t.Fatalf("failed transaction was applied: %v %v", p, err)
+		}
+
+		deleted := putPost(t, s, ctx, nil, &post{Title: "c"})
+		err = s.RunInTransaction(ctx, func(tc context.Context) error {
+			if err := s.RunInTransaction(tc, func(context.Context) error { return nil }); err != store.ErrNestedTransaction {
+				t.Errorf("got %v for nested transaction", err)
+			}
+			if _, err := s.Put(tc, key, &post{Title: "b"}); err != nil {
+				return err
+			}
+			return s.Delete(tc, deleted)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Get(ctx, key, &p); err != nil || p.Title != "b" {
+			t.Errorf("transaction wasn't applied: %v %v", p, err)
+		}
+		entities, _, err := s.Run(ctx, store.NewQuery("post"))
+		if err != nil || len(entities) != 1 {
+			t.Errorf("got %d entities, %v", len(entities), err)
+		}
+	})
+}
+
+func TestQuery(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+		for i, title := range []string{"a", "b", "c", "d"} {
+			putPost(t, s, ctx, nil, &post{Title: title, Tags: []string{"all"}, Score: i, Body: title})
+		}
+
+		nsCtx, err := appengine.Namespace(ctx, "project")
+		if err != nil {
+			t.Fatal(err)
+		}
+		putPost(t, s, nsCtx, nil, &post{Title: "e", Score: 10})
+
+		var titles = func(entities []*store.Entity) []string {
+			var out []string
+			for _, e := range entities {
+				var p post
+				if err := datastore.LoadStruct(&p, e.Properties); err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, p.Title)
+			}
+			return out
+		}
+
+		q := store.NewQuery("post").Filter("Score", ">=", int64(1)).Order("Score", true)
+		q.Limit = 2
+		entities, cursor, err := s.Run(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(entities); !reflect.DeepEqual(got, []string{"d", "c"}) {
+			t.Errorf("first page %v", got)
+		}
+
+		q.Cursor = cursor
+		entities, _, err = s.Run(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(entities); !reflect.DeepEqual(got, []string{"b"}) {
+			t.Errorf("second page %v", got)
+		}
+
+		q.Cursor = "!"
+		if _, _, err := s.Run(ctx, q); err != store.ErrInvalidCursor {
+			t.Errorf("got %v for invalid cursor", err)
+		}
+
+		// unindexed properties never match a filter
+		entities, _, err = s.Run(ctx, store.NewQuery("post").Filter("Body", "=", "a"))
+		if err != nil || len(entities) != 0 {
+			t.Errorf("got %d entities, %v", len(entities), err)
+		}
+
+		// without ordering entities are ordered by key
+		q = store.NewQuery("post").Filter("Tags", "=", "all")
+		q.Limit = 3
+		entities, cursor, err = s.Run(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(entities); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+			t.Errorf("first page by key %v", got)
+		}
+		q.Cursor = cursor
+		entities, _, err = s.Run(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(entities); !reflect.DeepEqual(got, []string{"d"}) {
+			t.Errorf("second page by key %v", got)
+		}
+
+		entities, _, err = s.Run(nsCtx, store.NewQuery("post"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(entities); !reflect.DeepEqual(got, []string{"e"}) {
+			t.Errorf("namespace entities %v", got)
+		}
+	})
+}
+
+// Kinds and their field workers run against stores without App Engine
+func TestKind(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		var ctx = context.Background()
+
+		categories := field.NewCategories()
+		categories.Store = s
+		k := kind.New("post", []*kind.Field{
+			{Name: "title", IsRequired: true, Worker: &field.Text{}},
+			{Name: "category", Worker: &field.Category{Kind: categories}},
+		})
+		k.Store = s
+		if err := k.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		c := categories.NewHolder(ctx, nil)
+		if err := c.ParseInput([]byte(`{"name":"News"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Add(); err != nil {
+			t.Fatal(err)
+		}
+		categoryId := c.Output()["id"].(string)
+
+		h := k.NewHolder(ctx, nil)
+		if err := h.ParseInput([]byte(`{"title":"a","category":"` + categoryId + `"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Add(); err != nil {
+			t.Fatal(err)
+		}
+		key, err := datastore.DecodeKey(h.Output()["id"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Update keeps the old version as a child of the entry
+		u := k.NewHolder(ctx, nil)
+		if err := u.ParseInput([]byte(`{"title":"b"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.Update(key); err != nil {
+			t.Fatal(err)
+		}
+
+		holders, _, err := k.List(ctx, store.NewQuery(k.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(holders) != 1 {
+			t.Fatalf("got %d entries", len(holders))
+		}
+		out := holders[0].Output()
+		if out["title"] != "b" {
+			t.Errorf("got title %v", out["title"])
+		}
+		if category, ok := out["category"].(map[string]interface{}); !ok || category["name"] != "News" {
+			t.Errorf("got category %v", out["category"])
+		}
+
+		entities, _, err := s.Run(ctx, &store.Query{Kind: k.Name, Ancestor: key})
+		if err != nil || len(entities) != 2 {
+			t.Errorf("got %d versions, %v", len(entities), err)
+		}
+	})
+}
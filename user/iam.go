@@ -6,54 +6,120 @@ import (
 )
 
 // userGroup: entityName: scope
-type Permissions map[string][]string // {"public":["post:read"], "editor":["post:*"], "admin":["*:*"]}
-func (p Permissions) parse() permissions {
-	var perms = permissions{}
+type Permissions map[string][]string // {"public":["post:read"], "author":["post:update:own"], "editor":["post:*"], "admin":["*:*"]}
+func (p Permissions) Parse() Rules {
+	perms, err := p.parse()
+	if err != nil {
+		panic(err)
+	}
+	return perms
+}
+
+// Checks permissions syntax; Parse panics with the same error
+func (p Permissions) Validate() error {
+	_, err := p.parse()
+	return err
+}
+
+func (p Permissions) parse() (Rules, error) {
+	var perms = Rules{}
 	for userGroupName, entityScopeArray := range p {
 		if _, ok := perms[userGroupName]; !ok {
 			perms[userGroupName] = map[string]map[Scope]bool{}
 		}
 
 		for _, entityScope := range entityScopeArray {
-
-			// split
-			var splitEntityScope = strings.Split(entityScope, ":")
-			if len(splitEntityScope) != 2 {
-				panic(errors.New("invalid number of segments: " + entityScope + " allowed 2 separated with :"))
+			entityName, scope, err := ParsePermission(entityScope)
+			if err != nil {
+				return nil, err
 			}
 
-			// is scope valid
-			switch splitEntityScope[1] {
-			case "read":
-			case "create":
-			case "update":
-			case "delete":
-			case "*":
-				break
-			default:
-				panic(errors.New("invalid scope: " + splitEntityScope[1]))
+			if _, ok := perms[userGroupName][entityName]; !ok {
+				perms[userGroupName][entityName] = map[Scope]bool{}
 			}
 
-			if _, ok := perms[userGroupName][splitEntityScope[0]]; !ok {
-				perms[userGroupName][splitEntityScope[0]] = map[Scope]bool{}
-			}
+			perms[userGroupName][entityName][scope] = true
+		}
+	}
+
+	return perms, nil
+}
+
+// Parses "kind:scope" or "kind:scope:own" permission; kind name is returned in lower case
+func ParsePermission(entityScope string) (string, Scope, error) {
+	// split
+	var splitEntityScope = strings.Split(entityScope, ":")
+	if len(splitEntityScope) != 2 && len(splitEntityScope) != 3 {
+		return "", "", errors.New("invalid number of segments: " + entityScope + " allowed 2 or 3 separated with :")
+	}
+
+	// is scope valid
+	switch splitEntityScope[1] {
+	case "read":
+	case "create":
+	case "update":
+	case "delete":
+	case "*":
+		break
+	default:
+		return "", "", errors.New("invalid scope: " + splitEntityScope[1])
+	}
 
-			perms[userGroupName][splitEntityScope[0]][Scope(splitEntityScope[1])] = true
+	// owner scope limits access to entries created by the user
+	var scope = Scope(splitEntityScope[1])
+	if len(splitEntityScope) == 3 {
+		if splitEntityScope[2] != "own" || scope == Create {
+			return "", "", errors.New("invalid scope: " + entityScope + " only read, update and delete can be limited to own")
 		}
+		scope = scope.Own()
 	}
 
-	return perms
+	// kind names are matched case insensitive
+	return strings.ToLower(splitEntityScope[0]), scope, nil
 }
 
 // userGroup: entityName: scope: true|false
-type permissions map[string]map[string]map[Scope]bool // {"public":{"post":{"read":true}}}
+type Rules map[string]map[string]map[Scope]bool // {"public":{"post":{"read":true}}}
+
+// Checks if user group has scope on entity; "*" matches any entity or scope.
+// Owner scopes (scope.Own()) are also granted by the matching full scope.
+func (r Rules) HasScope(userGroup string, entityName string, scope Scope) bool {
+	entities, ok := r[userGroup]
+	if !ok {
+		return false
+	}
+	for _, name := range []string{strings.ToLower(entityName), "*"} {
+		if scopes, ok := entities[name]; ok {
+			if scopes[scope] || scopes["*"] {
+				return true
+			}
+			if scope.IsOwn() && (scopes[Scope(strings.TrimSuffix(string(scope), ownSuffix))] || scopes[Scope("*"+ownSuffix)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
 type Scope string
 
+const ownSuffix = ":own"
+
+// Scope limited to entries created by the user, e.g. "update:own"
+func (s Scope) Own() Scope {
+	if s.IsOwn() {
+		return s
+	}
+	return s + ownSuffix
+}
+
+func (s Scope) IsOwn() bool {
+	return strings.HasSuffix(string(s), ownSuffix)
+}
+
 var (
 	Read   Scope = "read"
 	Create Scope = "create"
 	Update Scope = "update"
 	Delete Scope = "delete"
 )
-
@@ -4,12 +4,22 @@ import (
 	"github.com/ales6164/go-cms/project"
 )
 
+// Group every caller belongs to, including anonymous ones; it can't be assigned
+const PublicGroup = "public"
+
 // namespace is email
 type User struct {
-	Hash      []byte             `datastore:"hash,noindex" json:"-"`
-	Email     string             `datastore:"email" json:"email"`
-	FirstName string             `datastore:"firstName" json:"firstName"`
-	LastName  string             `datastore:"lastName" json:"lastName"`
-	Photo     string             `datastore:"photo,noindex" json:"photo"`
-	Projects  []*project.Project `datastore:"-" json:"projects"`
+	Hash          []byte             `datastore:"hash,noindex" json:"-"`
+	Email         string             `datastore:"email" json:"email"`
+	FirstName     string             `datastore:"firstName" json:"firstName"`
+	LastName      string             `datastore:"lastName" json:"lastName"`
+	Photo         string             `datastore:"photo,noindex" json:"photo"`
+	Groups        []string           `datastore:"groups" json:"groups"`
+	EmailVerified bool               `datastore:"emailVerified" json:"emailVerified"`
+	TOTPEnabled   bool               `datastore:"totpEnabled" json:"totpEnabled"`
+	TOTPSecret    string             `datastore:"totpSecret,noindex" json:"-"`
+	TOTPCounter   int64              `datastore:"totpCounter,noindex" json:"-"`   // last time step used, codes can't be reused
+	RecoveryCodes []string           `datastore:"recoveryCodes,noindex" json:"-"` // sha256 hashes of unused recovery codes
+	Disabled      bool               `datastore:"disabled" json:"disabled"`
+	Projects      []*project.Project `datastore:"-" json:"projects"`
 }
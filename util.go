@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"reflect"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -28,3 +30,12 @@ func clear(b []byte) {
 		b[i] = 0
 	}
 }
+
+// random base64url encoded string of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}